@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+)
+
+// serveAPI listens on addr and answers queries over HTTP: GET /now returns
+// the current reading, in the same JSON as --json, GET /history the
+// samples the sparklines cover, oldest first, and GET /stats the session
+// so far, in the same JSON as --summary. Like servePrometheus, it listens
+// up front and serves in the background.
+func serveAPI(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /now", handleNow)
+	mux.HandleFunc("GET /history", handleHistory)
+	mux.HandleFunc("GET /stats", handleStats)
+	go http.Serve(ln, mux)
+	return nil
+}
+
+func handleNow(w http.ResponseWriter, r *http.Request) {
+	b, err := sampleJSON(data.Sample())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(b, '\n'))
+}
+
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	samples := []json.RawMessage{}
+	for _, s := range history.Samples() {
+		b, err := sampleJSON(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		samples = append(samples, b)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(samples)
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summarize())
+}
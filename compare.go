@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"powermon/power"
+	"powermon/render"
+)
+
+// loggedSession is a --csv log played back through a fresh Stats, so it's
+// measured exactly as a live session would be.
+type loggedSession struct {
+	stats      power.Stats
+	end        time.Time
+	endBattery int
+}
+
+// loadSession reads a --csv log. Logs appended to across runs repeat the
+// header, which is skipped wherever it appears.
+func loadSession(path string) (*loggedSession, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ls := &loggedSession{}
+	ls.stats.Init(1, 0)
+	ls.stats.MaxGap = time.Minute
+	r := csv.NewReader(f)
+	var col map[string]int
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if row[0] == "timestamp" {
+			col = map[string]int{}
+			for i, name := range row {
+				col[name] = i
+			}
+			continue
+		}
+		if col == nil {
+			return nil, errors.New("no header row")
+		}
+		field := func(name string) float64 {
+			i, ok := col[name]
+			if !ok || i >= len(row) {
+				return 0
+			}
+			v, _ := strconv.ParseFloat(row[i], 64)
+			return v
+		}
+		t, err := time.Parse(time.RFC3339, row[0])
+		if err != nil {
+			return nil, err
+		}
+		s := power.Sample{
+			Time:         t,
+			CPUWatts:     field("cpu_w"),
+			GPUWatts:     field("gpu_w"),
+			ANEWatts:     field("ane_w"),
+			PackageWatts: field("package_w"),
+			BatteryPct:   int(field("battery_pct")),
+			BatteryWatts: field("battery_w"),
+		}
+		s.BatteryLevel = float64(s.BatteryPct)
+		ls.stats.Record(s)
+		ls.end, ls.endBattery = t, s.BatteryPct
+	}
+	if ls.stats.TotalChip.N == 0 {
+		return nil, errors.New("no samples")
+	}
+	return ls, nil
+}
+
+// compareSessions prints two --csv logs side by side, in green where one
+// session did better than the other and red where it did worse.
+func compareSessions(w io.Writer, pathA, pathB string) error {
+	a, err := loadSession(pathA)
+	if err != nil {
+		return fmt.Errorf("%s: %w", pathA, err)
+	}
+	b, err := loadSession(pathB)
+	if err != nil {
+		return fmt.Errorf("%s: %w", pathB, err)
+	}
+
+	width := max(len(filepath.Base(pathA)), len(filepath.Base(pathB)), 14)
+	cell := func(s, color string) string {
+		return color + fmt.Sprintf("%*s", width, s) + render.Reset
+	}
+	row := func(label string, va, vb string) {
+		fmt.Fprintf(w, "%-14s  %s  %s\n", label, va, vb)
+	}
+	// better colors a pair of readings, lower being better unless told
+	// otherwise; a tie stays plain
+	better := func(label, format string, va, vb float64, higher bool) {
+		ca, cb := "", ""
+		if va != vb {
+			ca, cb = render.Green, render.Red
+			if (va > vb) != higher {
+				ca, cb = cb, ca
+			}
+		}
+		row(label, cell(fmt.Sprintf(format, va), ca), cell(fmt.Sprintf(format, vb), cb))
+	}
+	avg := func(t power.Totals) float64 {
+		s, _ := t.Summary()
+		return s.Avg
+	}
+
+	row("", cell(filepath.Base(pathA), ""), cell(filepath.Base(pathB), ""))
+	row("Duration", cell(a.duration().String(), ""), cell(b.duration().String(), ""))
+	better("Chip avg", "%.2f W", avg(a.stats.TotalChip), avg(b.stats.TotalChip), false)
+	better("Chip peak", "%.2f W", a.stats.TotalChip.Max, b.stats.TotalChip.Max, false)
+	better("CPU avg", "%.2f W", avg(a.stats.TotalCPU), avg(b.stats.TotalCPU), false)
+	better("GPU avg", "%.2f W", avg(a.stats.TotalGPU), avg(b.stats.TotalGPU), false)
+	better("ANE avg", "%.2f W", avg(a.stats.TotalANE), avg(b.stats.TotalANE), false)
+	better("Chip energy", "%.3f Wh", a.stats.PackageWh, b.stats.PackageWh, false)
+	better("Battery drain", "%.3f Wh", a.stats.DrainWh, b.stats.DrainWh, false)
+	if a.stats.StartBattery > 0 && b.stats.StartBattery > 0 {
+		better("Battery", "%+.0f%%", float64(a.endBattery-a.stats.StartBattery), float64(b.endBattery-b.stats.StartBattery), true)
+	}
+	return nil
+}
+
+func (ls *loggedSession) duration() time.Duration {
+	return ls.end.Sub(ls.stats.Start)
+}
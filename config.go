@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultConfigPath is where the config file is read from unless --config
+// says otherwise: $XDG_CONFIG_HOME/powermon/config.toml, falling back to
+// ~/.config.
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "powermon", "config.toml")
+}
+
+// loadConfig sets flag defaults from a config file. It understands the
+// flat subset of TOML that flags need, one `name = value` per line, where
+// name is any long flag name:
+//
+//	interval = 500
+//	temp-unit = "F"
+//	no-color = true
+//
+// Flags given on the command line win over the file, which wins over the
+// built-in defaults. A missing file is only an error if it was asked for
+// with --config.
+func loadConfig(path string, required bool) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	onCommandLine := map[string]bool{}
+	flag.Visit(func(fl *flag.Flag) { onCommandLine[fl.Name] = true })
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: want name = value", path, n)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			// A quoted value runs to its closing quote, past any escaped
+			// ones; anything after is a comment
+			quoted, err := strconv.QuotedPrefix(value)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %v", path, n, err)
+			}
+			if value, err = strconv.Unquote(quoted); err != nil {
+				return fmt.Errorf("%s:%d: %v", path, n, err)
+			}
+		} else if i := strings.Index(value, "#"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+
+		if name == "config" || flag.Lookup(name) == nil {
+			return fmt.Errorf("%s:%d: unknown setting %q", path, n, name)
+		}
+		if onCommandLine[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s:%d: %s: %v", path, n, name, err)
+		}
+	}
+	return scanner.Err()
+}
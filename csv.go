@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"powermon/power"
+)
+
+var csvHeader = []string{
+	"timestamp", "cpu_w", "gpu_w", "ane_w", "package_w", "battery_pct",
+	"charger_w", "battery_w", "temp_c", "is_charging", "on_ac",
+}
+
+// csvLog appends one row per sample to a CSV file, flushing after every row
+// so an interrupted session keeps everything it collected.
+type csvLog struct {
+	f          *os.File
+	w          *csv.Writer
+	fahrenheit bool // temp_f column instead of temp_c
+}
+
+func openCSV(path string, fahrenheit bool) (*csvLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	l := &csvLog{f: f, w: csv.NewWriter(f), fahrenheit: fahrenheit}
+	if info.Size() == 0 {
+		header := csvHeader
+		if fahrenheit {
+			header = slices.Clone(csvHeader)
+			header[slices.Index(header, "temp_c")] = "temp_f"
+		}
+		l.w.Write(header)
+		l.w.Flush()
+		if err := l.w.Error(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Consume appends a row for s.
+func (l *csvLog) Consume(s power.Sample) error {
+	watts := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	temp := s.TempC
+	if l.fahrenheit {
+		temp = power.Fahrenheit(temp)
+	}
+	l.w.Write([]string{
+		s.Time.Format(time.RFC3339),
+		watts(s.CPUWatts),
+		watts(s.GPUWatts),
+		watts(s.ANEWatts),
+		watts(s.PackageWatts),
+		strconv.Itoa(s.BatteryPct),
+		strconv.Itoa(s.ChargerWatts),
+		watts(s.BatteryWatts),
+		strconv.FormatFloat(temp, 'f', 1, 64),
+		strconv.FormatBool(s.IsCharging),
+		strconv.FormatBool(s.OnAC),
+	})
+	l.w.Flush()
+	if err := l.w.Error(); err != nil {
+		return fmt.Errorf("writing CSV log: %w", err)
+	}
+	return nil
+}
+
+func (l *csvLog) Close() error {
+	l.w.Flush()
+	return l.f.Close()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"powermon/power"
+)
+
+// eventLog writes a timestamped line to a file whenever the power state
+// changes: plugging in or out, charging starting or stopping, and the
+// battery crossing a multiple of 10%. CPU power spikes, and gaps in the
+// samples from sleep or a clock change, are logged too.
+type eventLog struct {
+	f    *os.File
+	l    *log.Logger
+	last power.Sample
+	seen bool
+}
+
+func openEventLog(path string) (*eventLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLog{f: f, l: log.New(f, "", log.LstdFlags)}, nil
+}
+
+// Consume logs whatever s changed, and the spike or gap stats saw in it.
+func (e *eventLog) Consume(s power.Sample) error {
+	e.check(s)
+	st := stats.Snapshot()
+	spike, z, gap := st.Spike, st.SpikeScore, st.Gap
+	if spike {
+		e.spike(s, z)
+	}
+	if gap != 0 {
+		e.gap(gap)
+	}
+	return nil
+}
+
+func (e *eventLog) check(s power.Sample) {
+	if s.BatteryPct == 0 {
+		return // no reading yet
+	}
+	if !e.seen {
+		e.l.Printf("started: %s, battery %d%%", powerState(s), s.BatteryPct)
+		e.last, e.seen = s, true
+		return
+	}
+
+	last := e.last
+	e.last = s
+	if s.OnAC != last.OnAC {
+		if s.OnAC {
+			e.l.Printf("AC connected (%dW), battery %d%%", s.ChargerWatts, s.BatteryPct)
+		} else {
+			e.l.Printf("AC disconnected, battery %d%%", s.BatteryPct)
+		}
+	}
+	if s.IsCharging != last.IsCharging {
+		if s.IsCharging {
+			e.l.Printf("charging started, battery %d%%", s.BatteryPct)
+		} else {
+			e.l.Printf("charging stopped, battery %d%%", s.BatteryPct)
+		}
+	}
+	switch now, was := s.BatteryPct/10, last.BatteryPct/10; {
+	case now < was:
+		e.l.Printf("battery below %d%% (%d%%)", was*10, s.BatteryPct)
+	case now > was:
+		e.l.Printf("battery reached %d%% (%d%%)", now*10, s.BatteryPct)
+	}
+}
+
+// spike logs a CPU power spike, z standard deviations above the recent
+// mean.
+func (e *eventLog) spike(s power.Sample, z float64) {
+	e.l.Printf("CPU spike: %.2f W (z %.1f against the recent mean)", s.CPUWatts, z)
+}
+
+// gap logs a jump in the wall clock between samples, which energy isn't
+// integrated across.
+func (e *eventLog) gap(d time.Duration) {
+	e.l.Printf("gap detected (sleep or clock change?): %s between samples", d.Round(time.Second))
+}
+
+func (e *eventLog) Close() error {
+	return e.f.Close()
+}
+
+// powerState describes where the machine is getting its power.
+func powerState(s power.Sample) string {
+	switch {
+	case s.IsCharging:
+		return "charging"
+	case s.OnAC:
+		return "on AC, not charging"
+	default:
+		return "on battery"
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"powermon/power"
+)
+
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// influxLine formats s as an InfluxDB line protocol point in the powermon
+// measurement, tagged with the host name.
+func influxLine(s power.Sample, host string) string {
+	float := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	fields := []string{
+		"cpu_w=" + float(s.CPUWatts),
+		"gpu_w=" + float(s.GPUWatts),
+		"ane_w=" + float(s.ANEWatts),
+		"package_w=" + float(s.PackageWatts),
+		"battery_pct=" + strconv.Itoa(s.BatteryPct) + "i",
+		"charger_w=" + strconv.Itoa(s.ChargerWatts) + "i",
+		"battery_w=" + float(s.BatteryWatts),
+		"temp_c=" + float(s.TempC),
+		"is_charging=" + strconv.FormatBool(s.IsCharging),
+		"on_ac=" + strconv.FormatBool(s.OnAC),
+	}
+	return fmt.Sprintf("powermon,host=%s %s %d", influxTagEscaper.Replace(host), strings.Join(fields, ","), s.Time.UnixNano())
+}
+
+// influxWriter POSTs points to an InfluxDB /write endpoint, e.g.
+// http://localhost:8086/write?db=power. Writes happen in the background so
+// a slow server can't stall the display; the first failure is returned
+// by the next Consume.
+type influxWriter struct {
+	url     string
+	host    string // the host tag
+	client  *http.Client
+	pending sync.WaitGroup
+
+	mu  sync.Mutex
+	err error // the first failed write
+}
+
+func newInfluxWriter(url, host string) *influxWriter {
+	return &influxWriter{url: url, host: host, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+// Consume sends s as a point in the background, and reports a write that
+// failed since.
+func (w *influxWriter) Consume(s power.Sample) error {
+	w.mu.Lock()
+	err := w.err
+	w.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("writing to InfluxDB: %w", err)
+	}
+	w.write(influxLine(s, w.host))
+	return nil
+}
+
+func (w *influxWriter) write(line string) {
+	w.pending.Add(1)
+	go func() {
+		defer w.pending.Done()
+		resp, err := w.client.Post(w.url, "text/plain; charset=utf-8", strings.NewReader(line+"\n"))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				err = errors.New(resp.Status)
+			}
+		}
+		if err != nil {
+			w.mu.Lock()
+			if w.err == nil {
+				w.err = err
+			}
+			w.mu.Unlock()
+		}
+	}()
+}
+
+// Close waits for writes still in flight.
+func (w *influxWriter) Close() error {
+	w.pending.Wait()
+	return nil
+}
@@ -1,308 +1,821 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
-	"os/exec"
 	"os/signal"
-	"regexp"
-	"strconv"
+	"slices"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
+
+	"powermon/power"
+	"powermon/render"
 )
 
-type PowerData struct {
-	// Live from powermetrics (1s updates)
-	CPUPower     float64
-	GPUPower     float64
-	ANEPower     float64
-	PackagePower float64
-	BatteryPct   int
-
-	// From ioreg (~30s updates, but we poll every 5s)
-	ChargerWatts   int
-	ChargerVoltage int
-	ChargerCurrent int
-	BatteryVoltage int
-	BatteryAmps    int
-	Temperature    int
-	IsCharging     bool
-	OnAC           bool
-
-	mu sync.RWMutex
-}
+// Shared state: sources write data, each tick folds a sample into stats
+// and history
+var (
+	data    power.PowerData
+	stats   power.Stats
+	history power.History
+)
 
-var data PowerData
-
-// ANSI colors
-const (
-	Reset   = "\033[0m"
-	Red     = "\033[31m"
-	Green   = "\033[32m"
-	Yellow  = "\033[33m"
-	Blue    = "\033[34m"
-	Magenta = "\033[35m"
-	Cyan    = "\033[36m"
-	White   = "\033[37m"
-	Dim     = "\033[2m"
+var (
+	jsonOut       = flag.Bool("json", false, "stream one JSON object per sample instead of the live display")
+	influx        = flag.Bool("influx", false, "stream one InfluxDB line protocol point per sample instead of the live display")
+	influxURL     = flag.String("influx-url", "", "also POST each sample to this InfluxDB write `URL` (e.g. http://localhost:8086/write?db=power)")
+	accessible    = flag.Bool("accessible", false, "print one plain sentence per sample, without colors or box drawing, for screen readers")
+	meter         = flag.Bool("meter", false, "show just a one-line battery gauge, repainted in place, for a small terminal window")
+	oneline       = flag.Bool("oneline", false, "print one plain status line per sample, e.g. for tmux")
+	tmplText      = flag.String("template", "", "print each sample through this Go text/`template` in place of the live display; see the README for its fields and functions")
+	tmplPath      = flag.String("template-out", "", "append --template's output to this `file` instead, keeping the live display")
+	format        = flag.String("format", render.DefaultFormat, "`template` for --oneline; placeholders: {cpu} {gpu} {ane} {chip} {bat} {batw} {charger} {temp} {state}")
+	interval      = flag.Int("interval", 1000, "powermetrics sampling interval in milliseconds (minimum 100)")
+	plistFormat   = flag.Bool("plist", false, "read powermetrics' structured plist output instead of parsing its text")
+	askpass       = flag.String("sudo-askpass", "", "have sudo get the password for powermetrics from this `program` instead of the terminal, for running headless (unneeded when run as root)")
+	renderRate    = flag.Duration("render-rate", 0, "redraw the live display every `interval` (default: the sampling interval)")
+	ioregInterval = flag.Int("ioreg-interval", 5000, "ioreg polling interval in milliseconds")
+	ioregEase     = flag.Bool("ioreg-interpolate", false, "ease battery voltage, current and temperature between ioreg reads, for a smooth display with a long --ioreg-interval")
+	smoothSplit   = flag.Duration("smooth-split", 0, "show the power split as a moving average over about this `long` (e.g. 30s), rather than jumping at each charger reading; logs still get the readings as read")
+	debounce      = flag.Int("debounce", 1, "number of ioreg reads in a row a plug or unplug must last before the display switches panels")
+	csvPath       = flag.String("csv", "", "append one row per sample to this CSV `file`")
+	logPath       = flag.String("logfile", "", "append power events (AC and charging changes, every 10% of battery, CPU spikes, gaps from sleep) to this `file`")
+	promAddr      = flag.String("prometheus", "", "serve Prometheus metrics at /metrics on this `address` (e.g. :9101)")
+	pprofAddr     = flag.String("pprof", "", "serve Go profiles of powermon itself at /debug/pprof/ on this `address` (e.g. localhost:6060)")
+	apiAddr       = flag.String("api", "", "serve the current reading at /now and session statistics at /stats, as JSON over HTTP on this `address` (e.g. localhost:9102)")
+	socketPath    = flag.String("socket", "", "stream one JSON object per sample to every client of a Unix socket at `path`")
+	recordPath    = flag.String("record", "", "save the raw powermetrics output to `file` for later replay")
+	compare       = flag.Bool("compare", false, "compare two --csv logs, given as arguments, side by side and exit")
+	replayPath    = flag.String("replay", "", "play back a `file` made with --record instead of running powermetrics")
+	statsWindow   = flag.Int("stats-window", 60, "number of recent samples the min/avg/max statistics cover")
+	smooth        = flag.Float64("smooth", 0, "smooth the displayed silicon power with a moving average of this `alpha` (0 < alpha <= 1, smaller is smoother; 0 disables)")
+	spikeZ        = flag.Float64("spike-z", 3, "flag CPU power more than this many standard deviations above the recent mean (0 disables)")
+	histogram     = flag.Bool("histogram", false, "show how the session's chip power is distributed across power levels")
+	historyLen    = flag.Int("history", 60, "number of recent samples the sparklines cover")
+	tempUnit      = flag.String("temp-unit", "C", "temperature `unit`, C or F; also applies to --json, --csv and --oneline")
+	precision     = flag.Int("precision", -1, "decimals on watt and volt readings in the live display (-1: 2 for the silicon rails, 1 or 2 elsewhere)")
+	fields        = flag.String("fields", "", "comma-separated `panels` to draw: silicon, process, histogram, io, fans, charger, battery, health, raw, footer (default all)")
+	theme         = flag.String("theme", "dark", "color `theme`: dark, light, solarized or mono")
+	colorMode     = flag.String("color", "auto", "use colors: `when` always, never, or auto (only on a terminal with a TERM that isn't dumb, unless $NO_COLOR or $FORCE_COLOR says otherwise)")
+	noColor       = flag.Bool("no-color", false, "disable colors, the same as --color never")
+	warnWatts     = flag.Float64("warn-watts", 10, "silicon bars turn yellow above this many watts")
+	critWatts     = flag.Float64("crit-watts", 20, "silicon bars turn red above this many watts")
+	milliwatts    = flag.Bool("mw", false, "show the silicon rails in whole milliwatts, for tuning idle power; their bars fill at 1 W unless --cpu-max and the like say otherwise")
+	cpuMax        = flag.Float64("cpu-max", 10, "watts that fill the CPU bar (and the P and E core bars)")
+	gpuMax        = flag.Float64("gpu-max", 10, "watts that fill the GPU bar")
+	aneMax        = flag.Float64("ane-max", 10, "watts that fill the ANE bar")
+	autoScale     = flag.Bool("autoscale", false, "stretch the silicon bars to the session's peak once it passes --cpu-max, --gpu-max or --ane-max")
+	notifyLow     = flag.Int("notify-low", 20, "notify when the battery drops to this percent on battery power (0 disables)")
+	notifyCrit    = flag.Int("notify-crit", 10, "notify when the battery drops to this critical percent (0 disables)")
+	onLow         = flag.String("on-low", "", "run this shell `command` when the battery drops to --notify-low; gets the percentage as $1 and $POWERMON_BATTERY")
+	onCrit        = flag.String("on-crit", "", "run this shell `command` when the battery drops to --notify-crit; gets the percentage as $1 and $POWERMON_BATTERY")
+	tempWarn      = flag.Float64("temp-warn", 40, "show the battery temperature in yellow from this many `degrees`, in --temp-unit (default 40°C; 0 disables)")
+	tempCrit      = flag.Float64("temp-crit", 45, "show the battery temperature in red, and notify, from this many `degrees`, in --temp-unit (default 45°C; 0 disables)")
+	onTemp        = flag.String("on-temp", "", "run this shell `command` when the battery reaches --temp-crit; gets the temperature as $1 and $POWERMON_TEMP")
+	samplers      = flag.String("samplers", "", "comma-separated powermetrics `samplers` to run on top of cpu_power, gpu_power, thermal and battery; network and disk turn on --show-io, smc --fans")
+	watchPID      = flag.Int("watch-pid", 0, "estimate the power of the process with this `pid` from its share of CPU time")
+	raw           = flag.Bool("raw", false, "show ioreg's readings as read, before unit conversion, and which of its fields matched, for parse bug reports")
+	showIO        = flag.Bool("show-io", false, "show network and disk throughput (adds powermetrics' network and disk samplers)")
+	fans          = flag.Bool("fans", false, "show fan speeds (Intel Macs and Linux; Apple Silicon's powermetrics has no smc sampler)")
+	asciiOnly     = flag.Bool("ascii", false, "draw bars and borders with plain ASCII, for terminals that garble block characters")
+	borderless    = flag.Bool("borderless", false, "print the panels' rows without the box around them")
+	compact       = flag.Bool("compact", false, "leave out the separator lines between panels")
+	configPath    = flag.String("config", "", "read default settings from this `file` (default $XDG_CONFIG_HOME/powermon/config.toml)")
+	debug         = flag.Bool("debug", false, "log parse problems, including rejected readings, to stderr")
+	boundsPath    = flag.String("bounds", "", "override the plausible ranges of ioreg readings from a `file` of \"key min max\" lines")
+	once          = flag.Bool("once", false, "print the first complete sample and exit")
+	dumpPath      = flag.String("dump", "", "on SIGUSR1, write the current reading as JSON to `file` (default stderr)")
+	summaryPath   = flag.String("summary", "", "on exit, write a JSON summary of the session (per-rail power, energy, battery change) to `file`")
+	baselinePath  = flag.String("baseline", "", "show how far each rail is above the average in this `file`, made with --save-baseline")
+	saveBaseline  = flag.String("save-baseline", "", "average each rail over the session (--duration, default 30s) and save it to `file` for --baseline")
+	duration      = flag.Duration("duration", 0, "stop after this `duration` and print a session summary")
+	checkBattery  = flag.Int("check-battery", 0, "take one reading, print the battery percentage and exit 1 if it's below this `percent`, for scripts (implies --once)")
+	timeout       = flag.Duration("timeout", 0, "with --once, give up if no sample arrives within this `duration` (0 waits forever)")
 )
 
-func colorBar(pct int, width int, color string) string {
-	if pct < 0 {
-		pct = 0
+func main() {
+	flag.Parse()
+
+	path, required := *configPath, true
+	if path == "" {
+		path, required = defaultConfigPath(), false
 	}
-	if pct > 100 {
-		pct = 100
+	if path != "" {
+		if err := loadConfig(path, required); err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading config:", err)
+			os.Exit(2)
+		}
 	}
-	filled := pct * width / 100
-	empty := width - filled
-	if empty < 0 {
-		empty = 0
+
+	streams := 0
+	for _, on := range []bool{*jsonOut, *oneline, *influx, *meter, *accessible, *tmplText != "" && *tmplPath == ""} {
+		if on {
+			streams++
+		}
 	}
-	return color + strings.Repeat("█", filled) + Reset + Dim + strings.Repeat("░", empty) + Reset
-}
+	if streams > 1 {
+		fmt.Fprintln(os.Stderr, "only one of --json, --oneline, --influx, --meter, --accessible and --template can be used")
+		os.Exit(2)
+	}
+	var tmplOut *templateOut
+	if *tmplText != "" {
+		t, err := render.ParseTemplate(*tmplText)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "--template:", err)
+			os.Exit(2)
+		}
+		tmplOut = &templateOut{tmpl: t, w: os.Stdout}
+	} else if *tmplPath != "" {
+		fmt.Fprintln(os.Stderr, "--template-out needs --template")
+		os.Exit(2)
+	}
+	if *duration < 0 {
+		fmt.Fprintln(os.Stderr, "--duration must be positive")
+		os.Exit(2)
+	}
+	if *saveBaseline != "" && *duration == 0 {
+		*duration = 30 * time.Second
+	}
+	if *checkBattery < 0 || *checkBattery > 100 {
+		fmt.Fprintln(os.Stderr, "--check-battery must be between 0 and 100")
+		os.Exit(2)
+	}
+	if *checkBattery > 0 {
+		*once = true
+	}
+	if *timeout < 0 || (*timeout > 0 && !*once) {
+		fmt.Fprintln(os.Stderr, "--timeout must be positive and needs --once")
+		os.Exit(2)
+	}
+	// The live display owns the screen; the streaming modes just print.
+	// Where the screen can't be repainted (a log, a pipe, TERM=dumb) the
+	// display prints a block per sample instead.
+	tui := streams == 0 && *checkBattery == 0
+	plain := tui && (os.Getenv("TERM") == "dumb" || !isTerminal(os.Stdout))
+
+	if *interval < 100 {
+		fmt.Fprintln(os.Stderr, "--interval must be at least 100ms")
+		os.Exit(2)
+	}
+	if *renderRate < 0 {
+		fmt.Fprintln(os.Stderr, "--render-rate must be positive")
+		os.Exit(2)
+	}
+	if *renderRate == 0 {
+		*renderRate = time.Duration(*interval) * time.Millisecond
+	}
+	if *ioregInterval <= 0 {
+		fmt.Fprintln(os.Stderr, "--ioreg-interval must be positive")
+		os.Exit(2)
+	}
+	if *statsWindow <= 0 {
+		fmt.Fprintln(os.Stderr, "--stats-window must be positive")
+		os.Exit(2)
+	}
+	if *smooth < 0 || *smooth > 1 {
+		fmt.Fprintln(os.Stderr, "--smooth must be between 0 and 1")
+		os.Exit(2)
+	}
+	stats.Init(*statsWindow, *smooth)
+	if *spikeZ < 0 {
+		fmt.Fprintln(os.Stderr, "--spike-z must not be negative")
+		os.Exit(2)
+	}
+	stats.SpikeZ = *spikeZ
+	// Well past any hiccup in sampling, so only sleep or a clock change
+	stats.MaxGap = max(10*time.Duration(*interval)*time.Millisecond, 10*time.Second)
+	if *historyLen <= 0 {
+		fmt.Fprintln(os.Stderr, "--history must be positive")
+		os.Exit(2)
+	}
+	history.Init(*historyLen)
+	if *warnWatts <= 0 || *critWatts < *warnWatts {
+		fmt.Fprintln(os.Stderr, "--warn-watts must be positive and no greater than --crit-watts")
+		os.Exit(2)
+	}
+	render.WarnWatts, render.CritWatts = *warnWatts, *critWatts
+	if *cpuMax <= 0 || *gpuMax <= 0 || *aneMax <= 0 {
+		fmt.Fprintln(os.Stderr, "--cpu-max, --gpu-max and --ane-max must be positive")
+		os.Exit(2)
+	}
+	// At idle, tens of milliwatts would barely move a 10 W bar
+	if *milliwatts {
+		set := map[string]bool{}
+		flag.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+		for name, full := range map[string]*float64{"cpu-max": cpuMax, "gpu-max": gpuMax, "ane-max": aneMax} {
+			if !set[name] {
+				*full = 1
+			}
+		}
+	}
+	render.CPUMax, render.GPUMax, render.ANEMax = *cpuMax, *gpuMax, *aneMax
+	render.Milliwatts = *milliwatts
+	render.AutoScale = *autoScale
+	render.SmoothSplit = *smoothSplit
+
+	if *notifyLow < 0 || *notifyCrit < 0 || *notifyLow > 100 || *notifyCrit > 100 {
+		fmt.Fprintln(os.Stderr, "--notify-low and --notify-crit must be between 0 and 100")
+		os.Exit(2)
+	}
+	// Every output that takes samples is added as it's set up
+	sinks := []sink{&batteryAlerts{low: *notifyLow, crit: *notifyCrit, onLow: *onLow, onCrit: *onCrit}}
 
-func splitBar(sysPct, batPct, width int) string {
-	if sysPct < 0 {
-		sysPct = 0
+	if *boundsPath != "" {
+		if err := power.LoadBounds(*boundsPath); err != nil {
+			fmt.Fprintln(os.Stderr, "Error loading bounds:", err)
+			os.Exit(1)
+		}
 	}
-	if batPct < 0 {
-		batPct = 0
+	if *debug {
+		power.DebugLog.SetOutput(os.Stderr)
 	}
-	sysBars := sysPct * width / 100
-	batBars := width - sysBars
-	if sysBars < 0 {
-		sysBars = 0
+	if *debounce < 1 {
+		fmt.Fprintln(os.Stderr, "--debounce must be at least 1")
+		os.Exit(2)
 	}
-	if batBars < 0 {
-		batBars = 0
+	power.DebounceReads = *debounce
+	power.StaleAfter = max(power.StaleAfter, 3*time.Duration(*ioregInterval)*time.Millisecond)
+
+	switch strings.ToUpper(*tempUnit) {
+	case "C":
+	case "F":
+		render.Fahrenheit = true
+	default:
+		fmt.Fprintln(os.Stderr, "--temp-unit must be C or F")
+		os.Exit(2)
 	}
-	return Cyan + strings.Repeat("█", sysBars) + Reset + Yellow + strings.Repeat("█", batBars) + Reset
-}
+	if *tempWarn < 0 || *tempCrit < 0 {
+		fmt.Fprintln(os.Stderr, "--temp-warn and --temp-crit can't be negative")
+		os.Exit(2)
+	}
+	// Thresholds given on the command line are in --temp-unit; the
+	// defaults are in °C either way
+	flag.Visit(func(fl *flag.Flag) {
+		for name, limit := range map[string]*float64{"temp-warn": tempWarn, "temp-crit": tempCrit} {
+			if fl.Name == name && render.Fahrenheit && *limit != 0 {
+				*limit = (*limit - 32) * 5 / 9
+			}
+		}
+	})
+	render.TempWarn, render.TempCrit = *tempWarn, *tempCrit
+	sinks = append(sinks, &tempAlerts{crit: *tempCrit, command: *onTemp})
 
-var ansiRe = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	if err := render.SetTheme(*theme); err != nil {
+		fmt.Fprintln(os.Stderr, "--theme:", err)
+		os.Exit(2)
+	}
+	switch *colorMode {
+	case "always":
+	case "never":
+		render.DisableColor()
+	case "auto":
+		if !colorSupported() {
+			render.DisableColor()
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "--color must be always, never or auto")
+		os.Exit(2)
+	}
+	if *noColor || *accessible {
+		render.DisableColor()
+	}
+	if *compare {
+		if flag.NArg() != 2 {
+			fmt.Fprintln(os.Stderr, "--compare needs two CSV logs: powermon --compare a.csv b.csv")
+			os.Exit(2)
+		}
+		if err := compareSessions(os.Stdout, flag.Arg(0), flag.Arg(1)); err != nil {
+			fmt.Fprintln(os.Stderr, "Error comparing sessions:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	// Check for what live sampling runs before opening any outputs, so a
+	// missing program is reported without leaving empty logs behind
+	if *replayPath == "" {
+		if err := power.Preflight(power.Config{SudoAskpass: *askpass}); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	}
+	if *precision < -1 || *precision > 6 {
+		fmt.Fprintln(os.Stderr, "--precision must be between 0 and 6")
+		os.Exit(2)
+	}
+	render.Precision = *precision
+	if *baselinePath != "" {
+		b, err := power.LoadBaseline(*baselinePath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading baseline:", err)
+			os.Exit(1)
+		}
+		render.Baseline = b
+	}
+	if *fields != "" {
+		if err := render.SetFields(*fields); err != nil {
+			fmt.Fprintln(os.Stderr, "--fields:", err)
+			os.Exit(2)
+		}
+		// Asking for a panel turns on what it needs
+		*histogram = *histogram || render.Fields["histogram"]
+		*showIO = *showIO || render.Fields["io"]
+		*fans = *fans || render.Fields["fans"]
+		*raw = *raw || render.Fields["raw"]
+	}
+	var extraSamplers []string
+	for _, name := range strings.Split(*samplers, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(knownSamplers, name) {
+			fmt.Fprintf(os.Stderr, "warning: unknown powermetrics sampler %q (known: %s)\n", name, strings.Join(knownSamplers, ", "))
+		}
+		extraSamplers = append(extraSamplers, name)
+		*showIO = *showIO || name == "network" || name == "disk"
+		*fans = *fans || name == "smc"
+	}
+	render.Plain = plain
+	render.ShowHistogram = *histogram
+	render.ShowIO = *showIO
+	render.ShowRaw = *raw
+	if *asciiOnly {
+		render.UseASCII()
+	}
+	render.Borderless = *borderless
+	render.Compact = *compact
+
+	if *csvPath != "" {
+		l, err := openCSV(*csvPath, render.Fahrenheit)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error opening CSV log:", err)
+			os.Exit(1)
+		}
+		defer l.Close()
+		sinks = append(sinks, l)
+	}
+	if *tmplPath != "" && tmplOut != nil {
+		f, err := os.OpenFile(*tmplPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error opening template output:", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		tmplOut.w = f
+	}
+	if tmplOut != nil {
+		sinks = append(sinks, tmplOut)
+	}
 
-func visibleLen(s string) int {
-	return len([]rune(ansiRe.ReplaceAllString(s, "")))
-}
+	if *logPath != "" {
+		l, err := openEventLog(*logPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error opening event log:", err)
+			os.Exit(1)
+		}
+		defer l.Close()
+		sinks = append(sinks, l)
+	}
 
-func line(content string) string {
-	const width = 52
-	visible := visibleLen(content)
-	pad := width - visible
-	if pad < 0 {
-		pad = 0
+	host, _ := os.Hostname()
+	if *influxURL != "" {
+		w := newInfluxWriter(*influxURL, host)
+		defer w.Close()
+		sinks = append(sinks, w)
 	}
-	return "║ " + content + strings.Repeat(" ", pad) + " ║"
-}
 
-// Poll ioreg for charger/battery hardware data
-func pollIoreg() {
-	patterns := map[string]*regexp.Regexp{
-		"watts":    regexp.MustCompile(`"Watts"=(\d+)`),
-		"adapterV": regexp.MustCompile(`"AdapterVoltage"=(\d+)`),
-		"adapterA": regexp.MustCompile(`"Current"=(\d+)`),
-		"batteryV": regexp.MustCompile(`"AppleRawBatteryVoltage" = (\d+)`),
-		"batteryA": regexp.MustCompile(`"Amperage" = (-?\d+)`),
-		"temp":     regexp.MustCompile(`"Temperature" = (\d+)`),
-		"charging": regexp.MustCompile(`"IsCharging" = (Yes|No)`),
-		"external": regexp.MustCompile(`"ExternalConnected" = (Yes|No)`),
-	}
-
-	// Only returns value if in sane range, otherwise returns (0, false)
-	extractInt := func(s string, re *regexp.Regexp, min, max int) (int, bool) {
-		if m := re.FindStringSubmatch(s); len(m) > 1 {
-			v, err := strconv.Atoi(m[1])
-			if err == nil && v >= min && v <= max {
-				return v, true
-			}
+	if *promAddr != "" {
+		if err := servePrometheus(*promAddr); err != nil {
+			fmt.Fprintln(os.Stderr, "Error starting Prometheus endpoint:", err)
+			os.Exit(1)
 		}
-		return 0, false
 	}
 
-	for {
-		out, err := exec.Command("ioreg", "-rn", "AppleSmartBattery").Output()
-		if err == nil {
-			s := string(out)
-			data.mu.Lock()
-			if v, ok := extractInt(s, patterns["watts"], 0, 500); ok {
-				data.ChargerWatts = v
-			}
-			if v, ok := extractInt(s, patterns["adapterV"], 0, 50000); ok {
-				data.ChargerVoltage = v
-			}
-			if v, ok := extractInt(s, patterns["adapterA"], 0, 10000); ok {
-				data.ChargerCurrent = v
-			}
-			if v, ok := extractInt(s, patterns["batteryV"], 5000, 25000); ok {
-				data.BatteryVoltage = v
-			}
-			if v, ok := extractInt(s, patterns["batteryA"], -15000, 15000); ok {
-				data.BatteryAmps = v
-			}
-			if v, ok := extractInt(s, patterns["temp"], 0, 10000); ok {
-				data.Temperature = v
-			}
-			if m := patterns["charging"].FindStringSubmatch(s); len(m) > 1 {
-				data.IsCharging = m[1] == "Yes"
-			}
-			if m := patterns["external"].FindStringSubmatch(s); len(m) > 1 {
-				data.OnAC = m[1] == "Yes"
-			}
-			data.mu.Unlock()
+	if *apiAddr != "" {
+		if err := serveAPI(*apiAddr); err != nil {
+			fmt.Fprintln(os.Stderr, "Error starting HTTP API:", err)
+			os.Exit(1)
 		}
-		time.Sleep(5 * time.Second)
 	}
-}
-
-func main() {
-	fmt.Print("\033[?25l")     // hide cursor
-	fmt.Print("\033[H\033[2J") // clear
 
-	// Start ioreg polling in background
-	go pollIoreg()
-
-	// Launch powermetrics
-	cmd := exec.Command("sudo", "powermetrics",
-		"--samplers", "cpu_power,gpu_power,battery",
-		"-i", "1000",
-		"-f", "text")
+	if *pprofAddr != "" {
+		if err := servePprof(*pprofAddr); err != nil {
+			fmt.Fprintln(os.Stderr, "Error starting pprof endpoint:", err)
+			os.Exit(1)
+		}
+	}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		fmt.Println("Error:", err)
-		return
+	var hub *socketHub
+	if *socketPath != "" {
+		h, err := serveSocket(*socketPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error opening socket:", err)
+			os.Exit(1)
+		}
+		defer h.Close()
+		hub = h
+		sinks = append(sinks, h)
 	}
 
-	if err := cmd.Start(); err != nil {
-		fmt.Println("Error starting powermetrics (need sudo):", err)
-		return
+	var rec *power.Recorder
+	if *recordPath != "" {
+		r, err := power.CreateRecording(*recordPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error creating recording:", err)
+			os.Exit(1)
+		}
+		defer r.Close()
+		rec = r
 	}
 
-	// Handle Ctrl+C: kill powermetrics and restore cursor
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt)
-	go func() {
-		<-sig
-		cmd.Process.Kill()
-		fmt.Print("\033[?25h\n")
-		os.Exit(0)
-	}()
+	// The primary source drives the session, which ends when it does.
+	// Background sources fill in the rest alongside it.
+	var (
+		primary    power.PowerSource
+		background []power.PowerSource
+	)
+	if *replayPath != "" {
+		// Replays carry no ioreg data, so don't mix in live readings
+		r, err := power.OpenReplay(*replayPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error opening replay:", err)
+			os.Exit(1)
+		}
+		primary = &power.PowermetricsSource{Input: r, Recorder: rec}
+	} else {
+		var err error
+		primary, background, err = power.LiveSources(power.Config{
+			Interval:      time.Duration(*interval) * time.Millisecond,
+			IoregInterval: time.Duration(*ioregInterval) * time.Millisecond,
+			IoregEase:     *ioregEase,
+			Plist:         *plistFormat,
+			SudoAskpass:   *askpass,
+			Fans:          *fans,
+			IO:            *showIO,
+			Samplers:      extraSamplers,
+			Recorder:      rec,
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	}
 
-	defer cmd.Process.Kill()
+	// Whether there's ioreg data for --once to wait for
+	hasIoreg := len(background) > 0
+	if *watchPID != 0 {
+		if *watchPID < 0 || syscall.Kill(*watchPID, 0) == syscall.ESRCH {
+			fmt.Fprintf(os.Stderr, "--watch-pid: no process %d\n", *watchPID)
+			os.Exit(2)
+		}
+		background = append(background, &power.ProcessSource{PID: *watchPID, Every: time.Duration(*interval) * time.Millisecond})
+	}
 
-	scanner := bufio.NewScanner(stdout)
+	// Track the terminal size so the box fits it
+	winch := make(chan os.Signal, 1)
+	if tui && !plain {
+		// sudo prompts for powermetrics on the terminal, so get that done
+		// before the display takes the screen and the keyboard for itself
+		if *replayPath == "" {
+			if err := power.Authenticate(power.Config{SudoAskpass: *askpass}); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				os.Exit(1)
+			}
+		}
+		render.SetWidth(terminalWidth())
+		signal.Notify(winch, syscall.SIGWINCH)
+
+		// A --once snapshot stays on screen; the live display gets the
+		// alternate screen so it leaves the user's scrollback alone
+		if !*once {
+			altScreen = true
+			fmt.Print("\033[?1049h")
+		}
+		fmt.Print("\033[?25l")     // hide cursor
+		fmt.Print("\033[H\033[2J") // clear
+		defer restoreTerminal()
+	}
 
-	// Regex patterns for powermetrics
-	cpuPowerRe := regexp.MustCompile(`CPU Power:\s+([\d.]+)\s+mW`)
-	gpuPowerRe := regexp.MustCompile(`GPU Power:\s+([\d.]+)\s+mW`)
-	anePowerRe := regexp.MustCompile(`ANE Power:\s+([\d.]+)\s+mW`)
-	packageRe := regexp.MustCompile(`Combined Power \(CPU \+ GPU \+ ANE\):\s+([\d.]+)\s+mW`)
-	batteryPctRe := regexp.MustCompile(`percent_charge:\s+(\d+)`)
+	// Single-key controls, when there's a keyboard to read
+	keys := make(chan string)
+	paused := false
+	if tui && !plain && !*once && isTerminal(os.Stdin) {
+		if restore, err := rawInput(); err == nil {
+			restoreInput = restore
+			go readKeys(keys)
+			render.Hint = keyHint
+		}
+	}
 
-	for scanner.Scan() {
-		text := scanner.Text()
+	mon := &power.Monitor{
+		Data:          &data,
+		Primary:       primary,
+		Background:    background,
+		Restart:       *replayPath == "",
+		FallbackEvery: time.Duration(*interval) * time.Millisecond,
+	}
+	samples := mon.Subscribe()
+	render.Started = time.Now()
+	// Ctrl+C ends the session like any other way out of the loop below,
+	// so the deferred cleanup flushes and closes every log
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stopSignals()
+	if err := mon.Start(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	defer func() {
+		mon.Stop()
+		// The recording is written from powermetrics' source
+		if rec != nil {
+			mon.Wait()
+		}
+	}()
 
-		data.mu.Lock()
-		if m := cpuPowerRe.FindStringSubmatch(text); m != nil {
-			data.CPUPower, _ = strconv.ParseFloat(m[1], 64)
+	saveSummary := func() {
+		if *summaryPath != "" {
+			if err := writeSummary(*summaryPath); err != nil {
+				fmt.Fprintln(os.Stderr, "Error writing summary:", err)
+			}
 		}
-		if m := gpuPowerRe.FindStringSubmatch(text); m != nil {
-			data.GPUPower, _ = strconv.ParseFloat(m[1], 64)
+		if *saveBaseline != "" {
+			if err := stats.Baseline().Save(*saveBaseline); err != nil {
+				fmt.Fprintln(os.Stderr, "Error saving baseline:", err)
+			}
 		}
-		if m := anePowerRe.FindStringSubmatch(text); m != nil {
-			data.ANEPower, _ = strconv.ParseFloat(m[1], 64)
+	}
+	defer saveSummary()
+
+	// Leave the shell prompt under the meter rather than on it
+	endMeter := func() {
+		if *meter && isTerminal(os.Stdout) {
+			fmt.Println()
 		}
-		if m := packageRe.FindStringSubmatch(text); m != nil {
-			data.PackagePower, _ = strconv.ParseFloat(m[1], 64)
+	}
+	defer endMeter()
+
+	// For exits that bypass the deferred cleanup
+	shutdown := func() {
+		mon.Stop()
+		saveSummary()
+		if hub != nil {
+			hub.Close()
 		}
-		if m := batteryPctRe.FindStringSubmatch(text); m != nil {
-			data.BatteryPct, _ = strconv.Atoi(m[1])
+		if tui && !plain {
+			restoreTerminal()
 		}
-		data.mu.Unlock()
+		endMeter()
+	}
 
-		if strings.HasPrefix(text, "***") {
-			render()
+	// --once waits until the background sources have reported too, so the
+	// snapshot isn't missing the charger and battery
+	ready := func() bool {
+		if !*once || !hasIoreg {
+			return true
 		}
+		return !data.Snapshot().Updated["ioreg"].IsZero()
+	}
+	// kill -USR1 asks for the current reading, whatever the display is doing
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+
+	var expired, finished <-chan time.Time
+	if *timeout > 0 {
+		expired = time.After(*timeout)
+	}
+	if *duration > 0 {
+		finished = time.After(*duration)
 	}
-}
 
-func render() {
-	data.mu.RLock()
-	defer data.mu.RUnlock()
-
-	fmt.Print("\033[H") // cursor home
-
-	cpuW := data.CPUPower / 1000
-	gpuW := data.GPUPower / 1000
-	aneW := data.ANEPower / 1000
-	siliconW := data.PackagePower / 1000
-
-	chargerV := float64(data.ChargerVoltage) / 1000
-	chargerA := float64(data.ChargerCurrent) / 1000
-	batteryV := float64(data.BatteryVoltage) / 1000
-	batteryA := float64(data.BatteryAmps) / 1000
-	batteryW := batteryV * batteryA
-	tempC := float64(data.Temperature) / 100
-
-	fmt.Println("╔══════════════════════════════════════════════════════╗")
-	fmt.Println(line("       LIVE POWER MONITOR  (Ctrl+C to stop)"))
-	fmt.Println("╠══════════════════════════════════════════════════════╣")
-	fmt.Println(line(Magenta + "SILICON" + Reset + " (live)"))
-	fmt.Println(line(fmt.Sprintf("  CPU:  %5.2f W  [%s]", cpuW, colorBar(int(cpuW*10), 20, Magenta))))
-	fmt.Println(line(fmt.Sprintf("  GPU:  %5.2f W  [%s]", gpuW, colorBar(int(gpuW*10), 20, Magenta))))
-	fmt.Println(line(fmt.Sprintf("  ANE:  %5.2f W  [%s]", aneW, colorBar(int(aneW*10), 20, Magenta))))
-	fmt.Println(line(fmt.Sprintf("  Chip: %5.2f W", siliconW)))
-
-	fmt.Println("╠══════════════════════════════════════════════════════╣")
-
-	if data.OnAC {
-		systemW := float64(data.ChargerWatts) - batteryW
-		fmt.Println(line(Green + "CHARGER" + Reset))
-		fmt.Println(line(fmt.Sprintf("  %.1fV × %.2fA = " + Green + "%dW" + Reset, chargerV, chargerA, data.ChargerWatts)))
-		fmt.Println("╠══════════════════════════════════════════════════════╣")
-		fmt.Println(line("POWER SPLIT (~30s refresh)"))
-		fmt.Println(line(fmt.Sprintf("  → " + Cyan + "System:  %5.1f W" + Reset, systemW)))
-		fmt.Println(line(fmt.Sprintf("  → " + Yellow + "Battery: %5.1f W" + Reset, batteryW)))
-
-		// Visual split bar
-		if data.ChargerWatts > 0 {
-			barWidth := 40
-			batteryPct := int((batteryW / float64(data.ChargerWatts)) * 100)
-			if batteryPct < 0 {
-				batteryPct = 0
+	// The live display redraws on its own cadence rather than on sample
+	// separators, so it keeps up even if powermetrics' output changes.
+	// --once still draws exactly the sample it waited for.
+	var redraw <-chan time.Time
+	if tui && !plain && !*once {
+		redraw = time.Tick(*renderRate)
+	}
+
+	// Whatever has stdout goes last. --template's sink is already in, for
+	// stdout or a file.
+	sinks = append(sinks, sinkFunc(func(sample power.Sample) error {
+		switch {
+		case *jsonOut:
+			printJSON(sample)
+		case *oneline:
+			fmt.Println(render.Oneline(*format, sample))
+		case *influx:
+			fmt.Println(influxLine(sample, host))
+		case *accessible:
+			fmt.Println(render.Sentence(sample))
+		case *meter && isTerminal(os.Stdout):
+			fmt.Print("\r" + render.Meter(sample, terminalWidth()) + "\033[K")
+		case *meter:
+			fmt.Println(render.Meter(sample, 0))
+		case *checkBattery > 0:
+			fmt.Printf("battery %d%%\n", sample.BatteryPct)
+		case tui && redraw == nil:
+			draw()
+		}
+		return nil
+	}))
+
+	// Refresh every output from a new sample
+	tick := func(sample power.Sample) {
+		stats.Record(sample)
+		history.Record(sample)
+		// The history view stays on the sample it was looking at
+		if browse >= 0 {
+			browse = min(browse+1, history.Len()-1)
+		}
+		// An output that fails is reported once and dropped, rather than
+		// on every sample
+		kept := sinks[:0]
+		for _, out := range sinks {
+			if err := out.Consume(sample); err != nil {
+				warn(err)
+				continue
 			}
-			if batteryPct > 100 {
-				batteryPct = 100
+			kept = append(kept, out)
+		}
+		sinks = kept
+	}
+
+	for {
+		select {
+		case s, ok := <-samples:
+			if !ok {
+				// powermetrics gets the Ctrl+C too, which is no error
+				if err := mon.Err(); err != nil && ctx.Err() == nil {
+					fmt.Fprintln(os.Stderr, "Error:", err)
+				}
+				return
+			}
+			if !ready() {
+				continue
+			}
+			tick(s)
+			if *once {
+				if s.BatteryPct < *checkBattery {
+					shutdown()
+					os.Exit(1)
+				}
+				return
+			}
+		case <-redraw:
+			if !paused {
+				draw()
+			}
+		case k := <-keys:
+			switch k {
+			case "p":
+				paused = !paused
+				render.Hint = keyHint
+				if paused {
+					render.Hint = "PAUSED (p to resume)"
+				}
+				draw()
+			case "r":
+				stats.Reset()
+				draw()
+			case "h":
+				// The views differ in height, so clear the leftovers
+				if browse < 0 {
+					browse = 0
+				} else {
+					browse = -1
+				}
+				fmt.Print("\033[2J")
+				draw()
+			case "\033[D": // left
+				if browse < 0 {
+					fmt.Print("\033[2J")
+				}
+				browse = min(browse+1, max(history.Len()-1, 0))
+				draw()
+			case "\033[C": // right
+				if browse >= 0 {
+					browse = max(browse-1, 0)
+					draw()
+				}
+			case "q":
+				return
+			}
+		case <-finished:
+			shutdown()
+			// Keep the summary out of streamed output
+			if tui {
+				printSummary(os.Stdout)
+			} else {
+				printSummary(os.Stderr)
+			}
+			os.Exit(0)
+		case <-expired:
+			shutdown()
+			fmt.Fprintf(os.Stderr, "Error: no sample within %s\n", *timeout)
+			os.Exit(1)
+		case <-usr1:
+			if err := dumpSample(*dumpPath); err != nil {
+				fmt.Fprintln(os.Stderr, "Error writing dump:", err)
 			}
-			systemPct := 100 - batteryPct
-			fmt.Println(line(fmt.Sprintf("  [%s]", splitBar(systemPct, batteryPct, barWidth))))
-			fmt.Println(line(fmt.Sprintf("   " + Cyan + "system %d%%" + Reset + "          " + Yellow + "battery %d%%" + Reset, systemPct, batteryPct)))
+		case <-winch:
+			render.SetWidth(terminalWidth())
+			fmt.Print("\033[2J")
+			draw()
 		}
-	} else {
-		drainW := -batteryW
-		fmt.Println(line(Red + "ON BATTERY" + Reset))
-		fmt.Println(line(fmt.Sprintf("  Drain: " + Red + "%.1f W" + Reset, drainW)))
 	}
+}
+
+// knownSamplers are the samplers powermetrics documents; others are
+// passed through with a warning, in case a newer macOS has added them.
+var knownSamplers = []string{
+	"tasks", "battery", "network", "disk", "interrupts", "cpu_power",
+	"thermal", "sfi", "gpu_power", "ane_power", "smc", "gpu_agpm_stats",
+}
+
+const keyHint = "p pause  r reset  h history  q quit"
+
+// browse is how many samples back the history view is looking, or -1 for
+// the live display.
+var browse = -1
+
+// restoreInput undoes rawInput once the keyboard controls are on.
+var restoreInput = func() {}
+
+// altScreen is set while the live display is on the alternate screen.
+var altScreen bool
+
+// warn reports an error that doesn't end the session. The live display
+// shows it, since anything written to the terminal would land in the box.
+func warn(err error) {
+	if altScreen {
+		render.Alert = err.Error()
+		return
+	}
+	fmt.Fprintln(os.Stderr, "Error:", err)
+}
 
-	fmt.Println("╠══════════════════════════════════════════════════════╣")
-	fmt.Println(line(Yellow + "BATTERY" + Reset))
+// restoreTerminal puts back the cursor, screen and input mode the live
+// display changed.
+func restoreTerminal() {
+	restoreInput()
+	if altScreen {
+		fmt.Print("\033[?25h\033[?1049l")
+		return
+	}
+	fmt.Print("\033[?25h\n")
+}
 
-	status := Red + "draining" + Reset
-	if data.IsCharging {
-		status = Green + "charging" + Reset
-	} else if data.OnAC {
-		status = Blue + "full/maintaining" + Reset
+func printJSON(s power.Sample) {
+	b, err := sampleJSON(s)
+	if err != nil {
+		return
 	}
+	fmt.Println(string(b))
+}
 
-	fmt.Println(line(fmt.Sprintf("  %d%% │ %.2fV │ %dmA │ %.1f°C", data.BatteryPct, batteryV, data.BatteryAmps, tempC)))
-	fmt.Println(line(fmt.Sprintf("  %s", status)))
-	fmt.Println(line(fmt.Sprintf("  [%s]", colorBar(data.BatteryPct, 44, Yellow))))
+// sampleJSON encodes s as a single line, in the chosen temperature unit.
+func sampleJSON(s power.Sample) ([]byte, error) {
+	var v any = s
+	if render.Fahrenheit {
+		// The outer fields shadow the embedded temp_c
+		v = struct {
+			power.Sample
+			TempC *float64 `json:"temp_c,omitempty"`
+			TempF float64  `json:"temp_f"`
+		}{Sample: s, TempF: power.Fahrenheit(s.TempC)}
+	}
+	return json.Marshal(v)
+}
 
-	fmt.Println("╠══════════════════════════════════════════════════════╣")
-	fmt.Println(line(time.Now().Format("15:04:05")))
-	fmt.Println("╚══════════════════════════════════════════════════════╝")
-	fmt.Println()
+// dumpSample writes the current reading as JSON to path, replacing it
+// whole so readers never see half a file, or to stderr if path is empty.
+func dumpSample(path string) error {
+	b, err := sampleJSON(data.Sample())
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if path == "" {
+		_, err := os.Stderr.Write(b)
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// draw redraws the live display, or the history view, from the shared
+// state.
+func draw() {
+	if browse >= 0 {
+		fmt.Print(render.RenderHistory(&history, browse))
+		return
+	}
+	fmt.Print(render.Render(&data, &stats, &history))
 }
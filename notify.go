@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"powermon/power"
+	"powermon/render"
+)
+
+// batteryAlerts raises a desktop notification when the battery drops
+// through a threshold while unplugged. Each threshold fires once per
+// crossing and re-arms when the level climbs back above it or the charger
+// is connected. A threshold can also run a shell command when it fires.
+type batteryAlerts struct {
+	low, crit     int // percent; 0 disables
+	onLow, onCrit string
+	lowFired      bool
+	critFired     bool
+}
+
+// Consume alerts on s if it crosses a threshold.
+func (a *batteryAlerts) Consume(s power.Sample) error {
+	a.check(s)
+	return nil
+}
+
+func (a *batteryAlerts) check(s power.Sample) {
+	if s.BatteryPct == 0 {
+		return // no reading yet
+	}
+	if s.OnAC {
+		a.lowFired, a.critFired = false, false
+		return
+	}
+
+	if a.crit > 0 && s.BatteryPct > a.crit {
+		a.critFired = false
+	}
+	if a.low > 0 && s.BatteryPct > a.low {
+		a.lowFired = false
+	}
+
+	// Only the most severe alert fires when both thresholds are crossed at
+	// once, e.g. when starting up with a nearly empty battery
+	switch {
+	case a.crit > 0 && s.BatteryPct <= a.crit && !a.critFired:
+		a.critFired, a.lowFired = true, true
+		notify(fmt.Sprintf("Battery critically low: %d%%", s.BatteryPct))
+		runHook(a.onCrit, "POWERMON_BATTERY", strconv.Itoa(s.BatteryPct))
+	case a.low > 0 && s.BatteryPct <= a.low && !a.lowFired:
+		a.lowFired = true
+		notify(fmt.Sprintf("Battery low: %d%%", s.BatteryPct))
+		runHook(a.onLow, "POWERMON_BATTERY", strconv.Itoa(s.BatteryPct))
+	}
+}
+
+// tempAlerts raises a desktop notification when the battery temperature
+// climbs to crit, and can run a shell command too. It fires once per
+// crossing and re-arms when the temperature falls a degree below crit, so
+// a reading hovering at the line doesn't fire over and over.
+type tempAlerts struct {
+	crit    float64 // °C; 0 disables
+	command string
+	fired   bool
+}
+
+// Consume alerts on s if it crosses the threshold.
+func (a *tempAlerts) Consume(s power.Sample) error {
+	if a.crit == 0 || s.TempC == 0 {
+		return nil
+	}
+	switch {
+	case s.TempC < a.crit-1:
+		a.fired = false
+	case s.TempC >= a.crit && !a.fired:
+		a.fired = true
+		shown := render.Temperature(s.TempC)
+		notify(fmt.Sprintf("Battery hot: %s", shown))
+		runHook(a.command, "POWERMON_TEMP", strings.TrimRight(shown, "°CF"))
+	}
+	return nil
+}
+
+// notify shows a macOS notification without waiting for it.
+func notify(msg string) {
+	script := fmt.Sprintf("display notification %q with title %q", msg, "powermon")
+	go exec.Command("osascript", "-e", script).Run()
+}
+
+// runHook runs a user's alert command through the shell without waiting
+// for it. The reading is its first argument and in the environment as
+// env, e.g. the battery percentage as $POWERMON_BATTERY.
+func runHook(command, env, value string) {
+	if command == "" {
+		return
+	}
+	cmd := exec.Command("sh", "-c", command, "powermon", value)
+	cmd.Env = append(os.Environ(), env+"="+value)
+	go func() {
+		if err := cmd.Run(); err != nil {
+			power.DebugLog.Printf("alert command %q: %v", command, err)
+		}
+	}()
+}
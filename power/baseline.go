@@ -0,0 +1,56 @@
+package power
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Baseline is the average draw of each rail over a reference session, such
+// as an idle machine, in watts.
+type Baseline struct {
+	Taken   time.Time `json:"taken"`
+	Samples int       `json:"samples"`
+	CPU     float64   `json:"cpu_w"`
+	GPU     float64   `json:"gpu_w"`
+	ANE     float64   `json:"ane_w"`
+	Package float64   `json:"package_w"`
+}
+
+// Baseline averages the session so far.
+func (st *Stats) Baseline() Baseline {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	avg := func(t Totals) float64 {
+		s, _ := t.Summary()
+		return s.Avg
+	}
+	return Baseline{
+		Taken:   st.Start,
+		Samples: st.TotalChip.N,
+		CPU:     avg(st.TotalCPU),
+		GPU:     avg(st.TotalGPU),
+		ANE:     avg(st.TotalANE),
+		Package: avg(st.TotalChip),
+	}
+}
+
+func LoadBaseline(path string) (*Baseline, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var base Baseline
+	if err := json.Unmarshal(b, &base); err != nil {
+		return nil, err
+	}
+	return &base, nil
+}
+
+func (b Baseline) Save(path string) error {
+	out, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(out, '\n'), 0644)
+}
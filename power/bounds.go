@@ -0,0 +1,66 @@
+package power
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Range is an inclusive range of plausible values.
+type Range struct {
+	Min, Max int
+}
+
+// Bounds are the plausible ranges of the numeric ioreg fields, in ioreg's
+// own units, keyed like the ioreg patterns. Readings outside them are
+// dropped as glitches.
+var Bounds = map[string]Range{
+	"watts":      {0, 500},        // W
+	"adapterV":   {0, 50000},      // mV
+	"adapterA":   {0, 10000},      // mA
+	"batteryV":   {5000, 25000},   // mV
+	"batteryA":   {-15000, 15000}, // mA, negative while discharging
+	"temp":       {0, 10000},      // hundredths of °C
+	"systemLoad": {0, 500000},     // mW
+	"cycles":     {0, 10000},      // charge cycles
+	"designCap":  {500, 30000},    // mAh
+	"maxCap":     {0, 30000},      // mAh
+	"rawCap":     {0, 30000},      // mAh
+	"capacity":   {0, 30000},      // charge level: percent, or mAh on Intel
+	"fullCap":    {1, 30000},      // full charge level, in the same unit
+}
+
+// LoadBounds overrides Bounds from a file of "key min max" lines, for
+// hardware whose readings fall outside the defaults. Blank lines and lines
+// starting with # are ignored; keys that aren't listed keep their defaults.
+func LoadBounds(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return fmt.Errorf("%s:%d: want \"key min max\"", path, n)
+		}
+		if _, ok := Bounds[fields[0]]; !ok {
+			return fmt.Errorf("%s:%d: unknown key %q", path, n, fields[0])
+		}
+		min, err1 := strconv.Atoi(fields[1])
+		max, err2 := strconv.Atoi(fields[2])
+		if err1 != nil || err2 != nil || min > max {
+			return fmt.Errorf("%s:%d: bad range %s..%s", path, n, fields[1], fields[2])
+		}
+		Bounds[fields[0]] = Range{min, max}
+	}
+	return scanner.Err()
+}
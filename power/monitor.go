@@ -0,0 +1,249 @@
+package power
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Monitor runs a primary source, which paces the session, alongside any
+// number of background sources, and publishes a Sample each time the
+// primary completes one. It's what the powermon CLI is built on:
+//
+//	primary, background, err := power.LiveSources(cfg)
+//	...
+//	m := &power.Monitor{Primary: primary, Background: background}
+//	samples := m.Subscribe()
+//	m.Start(ctx)
+//	for s := range samples {
+//		...
+//	}
+//	if err := m.Err(); err != nil {
+//		...
+//	}
+//
+// Concurrency: a single goroutine applies every source's updates to Data
+// under its write lock, so read Data through Data.Snapshot and
+// Data.Sample, which take its read lock. Subscribers each get their own
+// buffered channel; one that falls behind misses samples rather than
+// stalling the others. Every channel is closed once the monitor stops,
+// after which Err reports why. Subscribe, Stop, Wait and Err are safe to
+// call from any goroutine.
+//
+// Stopping cancels the context every source runs under, and the monitor
+// keeps taking their updates until they have all returned, so none is
+// left blocked.
+type Monitor struct {
+	// Data accumulates the readings. Start allocates it if it's nil.
+	Data *PowerData
+
+	Primary    PowerSource
+	Background []PowerSource
+
+	// Restart brings the primary back, with backoff, if it stops after it
+	// has been sampling. Leave it off for replays, which should just end.
+	Restart bool
+
+	// FallbackEvery is how often to publish if the primary can't run at
+	// all (ErrNoPowermetrics) but there are background sources to show.
+	// Zero ends the session instead.
+	FallbackEvery time.Duration
+
+	mu      sync.Mutex
+	subs    []chan Sample
+	err     error
+	cancel  context.CancelFunc
+	sources sync.WaitGroup // sources' Runs under way
+}
+
+// Backoff bounds for restarting the primary source.
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// Subscribe returns a channel that receives every sample published from
+// now on.
+func (m *Monitor) Subscribe() <-chan Sample {
+	ch := make(chan Sample, 16)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subs = append(m.subs, ch)
+	return ch
+}
+
+// Start launches the sources and returns. The monitor runs until the
+// primary source finishes, ctx is cancelled, or Stop is called.
+func (m *Monitor) Start(ctx context.Context) error {
+	if m.Primary == nil {
+		return errors.New("monitor has no primary source")
+	}
+	if m.Data == nil {
+		m.Data = &PowerData{}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	m.mu.Lock()
+	m.cancel = cancel
+	m.mu.Unlock()
+	go m.run(ctx)
+	return nil
+}
+
+// Stop ends the session and kills powermetrics, if the primary launched it.
+func (m *Monitor) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel != nil {
+		m.cancel()
+	}
+}
+
+// Wait blocks until every source has returned after Stop, so whatever
+// they were writing, such as a recording, is complete. It gives up after a
+// second rather than hang on a source that's slow to notice.
+func (m *Monitor) Wait() {
+	finished := make(chan struct{})
+	go func() {
+		m.sources.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+	}
+}
+
+// Err reports why the monitor stopped: nil if the primary source simply
+// finished or the monitor was stopped.
+func (m *Monitor) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+func (m *Monitor) run(ctx context.Context) {
+	var err error
+	defer func() {
+		m.mu.Lock()
+		m.err = err
+		for _, ch := range m.subs {
+			close(ch)
+		}
+		m.subs = nil
+		m.mu.Unlock()
+	}()
+
+	updates := make(chan Update)
+	done := make(chan error, 1)
+	launch := func() {
+		m.sources.Add(1)
+		go func() {
+			defer m.sources.Done()
+			done <- m.Primary.Run(ctx, updates)
+		}()
+	}
+	// Once the monitor stops, cancel the sources and keep taking their
+	// updates until they have all returned, so none is left blocked
+	defer func() {
+		m.Stop()
+		finished := make(chan struct{})
+		go func() {
+			m.sources.Wait()
+			close(finished)
+		}()
+		go func() {
+			for {
+				select {
+				case <-updates:
+				case <-finished:
+					return
+				}
+			}
+		}()
+	}()
+	launch()
+	for _, src := range m.Background {
+		m.sources.Add(1)
+		go func() {
+			defer m.sources.Done()
+			src.Run(ctx, updates)
+		}()
+	}
+
+	// Without powermetrics there are no sample ticks, so the fallback
+	// publishes on a timer instead
+	var fallback <-chan time.Time
+
+	var (
+		sampled bool
+		restart <-chan time.Time
+		backoff = minBackoff
+	)
+	for {
+		select {
+		case u := <-updates:
+			m.Data.Apply(u)
+			if !u.Tick {
+				continue
+			}
+			if !sampled || m.Data.reconnecting() {
+				m.Data.mu.Lock()
+				m.Data.Reconnecting = false
+				m.Data.mu.Unlock()
+				sampled, backoff = true, minBackoff
+			}
+			m.publish()
+		case <-fallback:
+			m.publish()
+		case <-restart:
+			restart = nil
+			launch()
+		case err = <-done:
+			if sampled && m.Restart {
+				DebugLog.Printf("primary source stopped (%v), restarting in %s", err, backoff)
+				m.Data.mu.Lock()
+				m.Data.Reconnecting = true
+				m.Data.mu.Unlock()
+				restart = time.After(backoff)
+				backoff = min(backoff*2, maxBackoff)
+				continue
+			}
+			if errors.Is(err, ErrNoPowermetrics) && len(m.Background) > 0 && m.FallbackEvery > 0 {
+				m.Data.mu.Lock()
+				m.Data.NoSilicon = true
+				m.Data.mu.Unlock()
+				fallback = time.Tick(m.FallbackEvery)
+				done = nil
+				continue
+			}
+			return
+		case <-ctx.Done():
+			err = nil
+			return
+		}
+	}
+}
+
+// publish sends the current readings to every subscriber with room for
+// them.
+func (m *Monitor) publish() {
+	m.Data.mu.Lock()
+	m.Data.Samples++
+	m.Data.mu.Unlock()
+	s := m.Data.Sample()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ch := range m.subs {
+		select {
+		case ch <- s:
+		default:
+		}
+	}
+}
+
+func (p *PowerData) reconnecting() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.Reconnecting
+}
@@ -0,0 +1,174 @@
+package power
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// ticks returns n sample updates, the ith setting the CPU to i watts.
+func ticks(n int) []Update {
+	var us []Update
+	for i := 1; i <= n; i++ {
+		mw := float64(i * 1000)
+		us = append(us, Update{Apply: func(d *PowerData) { d.CPUPower = mw }, Tick: true})
+	}
+	return us
+}
+
+// collect reads samples until the channel closes, failing if it doesn't
+// within a few seconds.
+func collect(t *testing.T, ch <-chan Sample) []Sample {
+	t.Helper()
+	var got []Sample
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case s, ok := <-ch:
+			if !ok {
+				return got
+			}
+			got = append(got, s)
+		case <-timeout:
+			t.Fatal("samples channel wasn't closed")
+		}
+	}
+}
+
+func TestMonitorPublishesEachTick(t *testing.T) {
+	m := &Monitor{Primary: &FakeSource{Updates: append(ticks(3),
+		// Updates that aren't ticks only change the readings
+		Update{Apply: func(d *PowerData) { d.CPUPower = 9000 }})}}
+	samples := m.Subscribe()
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := collect(t, samples)
+	if len(got) != 3 {
+		t.Fatalf("got %d samples, want 3", len(got))
+	}
+	for i, s := range got {
+		if want := float64(i + 1); s.CPUWatts != want {
+			t.Errorf("sample %d: CPUWatts = %v, want %v", i, s.CPUWatts, want)
+		}
+	}
+	if err := m.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil once the source finishes", err)
+	}
+	if m.Data.Samples != 3 {
+		t.Errorf("Data.Samples = %d, want 3", m.Data.Samples)
+	}
+}
+
+func TestMonitorStop(t *testing.T) {
+	m := &Monitor{Primary: &FakeSource{Updates: ticks(10000)}}
+	samples := m.Subscribe()
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-samples
+	m.Stop()
+	m.Stop() // a second Stop is harmless
+
+	if got := collect(t, samples); len(got) >= 10000 {
+		t.Errorf("got all %d samples despite Stop", len(got))
+	}
+	if err := m.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil after Stop", err)
+	}
+}
+
+func TestMonitorCancel(t *testing.T) {
+	m := &Monitor{Primary: &FakeSource{Updates: ticks(10000)}}
+	samples := m.Subscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-samples
+	cancel()
+	collect(t, samples)
+	if err := m.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil after cancel", err)
+	}
+}
+
+// failingSource sends its updates and then fails.
+type failingSource struct {
+	FakeSource
+	err error
+}
+
+func (s *failingSource) Run(ctx context.Context, ch chan<- Update) error {
+	s.FakeSource.Run(ctx, ch)
+	return s.err
+}
+
+func TestMonitorErr(t *testing.T) {
+	boom := errors.New("boom")
+	m := &Monitor{Primary: &failingSource{FakeSource{ticks(1)}, boom}}
+	samples := m.Subscribe()
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(t, samples); len(got) != 1 {
+		t.Errorf("got %d samples, want 1", len(got))
+	}
+	if err := m.Err(); !errors.Is(err, boom) {
+		t.Errorf("Err() = %v, want %v", err, boom)
+	}
+}
+
+func TestMonitorNoPrimary(t *testing.T) {
+	if err := (&Monitor{}).Start(context.Background()); err == nil {
+		t.Error("Start with no primary succeeded")
+	}
+}
+
+// endlessSource sends updates until it's cancelled, then closes returned.
+type endlessSource struct {
+	returned chan struct{}
+}
+
+func (s *endlessSource) Run(ctx context.Context, ch chan<- Update) error {
+	defer close(s.returned)
+	for ctx.Err() == nil {
+		ch <- Update{Apply: func(d *PowerData) { d.BatteryPct = 50 }}
+	}
+	return nil
+}
+
+// Stopping has every background source return, not just the primary.
+func TestMonitorStopsBackground(t *testing.T) {
+	for _, stop := range []string{"Stop", "primary finishes"} {
+		t.Run(stop, func(t *testing.T) {
+			bg := []*endlessSource{{make(chan struct{})}, {make(chan struct{})}}
+			primary := ticks(1)
+			if stop == "Stop" {
+				primary = ticks(10000)
+			}
+			m := &Monitor{
+				Primary:    &FakeSource{Updates: primary},
+				Background: []PowerSource{bg[0], bg[1]},
+			}
+			samples := m.Subscribe()
+			if err := m.Start(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			<-samples
+			if stop == "Stop" {
+				m.Stop()
+			}
+			collect(t, samples)
+			for i, src := range bg {
+				select {
+				case <-src.returned:
+				case <-time.After(5 * time.Second):
+					t.Errorf("background source %d still running", i)
+				}
+			}
+		})
+	}
+}
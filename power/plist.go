@@ -0,0 +1,159 @@
+package power
+
+import (
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// powermetrics -f plist writes one XML property list per sample, each
+// preceded by a NUL byte. It carries the same readings as the text format
+// under stable key names, so nothing depends on the wording of a line.
+
+// isPlistLine reports whether a line of powermetrics output opens a plist
+// sample, which is how replays of plist recordings are recognized.
+func isPlistLine(text string) bool {
+	return strings.HasPrefix(strings.TrimLeft(text, "\x00"), "<?xml")
+}
+
+// parsePlist decodes one sample's property list into nested maps, slices,
+// strings, bools and float64s (integers included).
+func parsePlist(doc string) (map[string]any, error) {
+	dec := xml.NewDecoder(strings.NewReader(strings.TrimLeft(doc, "\x00")))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local == "plist" {
+			continue
+		}
+		v, err := plistValue(dec, start)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, errors.New("plist sample is not a dict")
+		}
+		return m, nil
+	}
+}
+
+// plistValue decodes the element that start opens.
+func plistValue(dec *xml.Decoder, start xml.StartElement) (any, error) {
+	switch start.Name.Local {
+	case "dict", "array":
+		m := map[string]any{}
+		var list []any
+		var key string
+		for {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				if t.Name.Local == "key" {
+					if err := dec.DecodeElement(&key, &t); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				v, err := plistValue(dec, t)
+				if err != nil {
+					return nil, err
+				}
+				if start.Name.Local == "dict" {
+					m[key] = v
+				} else {
+					list = append(list, v)
+				}
+			case xml.EndElement:
+				if start.Name.Local == "dict" {
+					return m, nil
+				}
+				return list, nil
+			}
+		}
+	case "true", "false":
+		return start.Name.Local == "true", dec.Skip()
+	case "integer", "real":
+		var s string
+		if err := dec.DecodeElement(&s, &start); err != nil {
+			return nil, err
+		}
+		return strconv.ParseFloat(strings.TrimSpace(s), 64)
+	default: // string, date, data
+		var s string
+		err := dec.DecodeElement(&s, &start)
+		return s, err
+	}
+}
+
+// plistNum looks up a number by its path through nested dicts.
+func plistNum(m map[string]any, path ...string) (float64, bool) {
+	for _, k := range path[:len(path)-1] {
+		m, _ = m[k].(map[string]any)
+	}
+	v, ok := m[path[len(path)-1]].(float64)
+	return v, ok
+}
+
+// applyPlist merges the readings in one plist sample into d, the
+// counterpart of parseLine. Keys a sampler didn't report leave d untouched.
+func applyPlist(m map[string]any, d *PowerData) {
+	if v, ok := plistNum(m, "processor", "cpu_power"); ok {
+		d.CPUPower = v
+	}
+	if v, ok := plistNum(m, "processor", "gpu_power"); ok {
+		d.GPUPower = v
+	}
+	if v, ok := plistNum(m, "processor", "ane_power"); ok {
+		d.ANEPower = v
+	}
+	if v, ok := plistNum(m, "processor", "combined_power"); ok {
+		d.PackagePower = v
+	}
+	if v, ok := plistNum(m, "processor", "package_watts"); ok {
+		d.PackagePower = v * 1000
+		d.Intel = true
+	}
+	processor, _ := m["processor"].(map[string]any)
+	clusters, _ := processor["clusters"].([]any)
+	for _, c := range clusters {
+		c, _ := c.(map[string]any)
+		name, _ := c["name"].(string)
+		mw, ok := c["power"].(float64)
+		if ok && name != "" {
+			d.setCluster(strings.TrimSuffix(name, "-Cluster"), mw)
+		}
+	}
+	if v, ok := plistNum(m, "gpu", "idle_ratio"); ok {
+		d.GPUActive = (1 - v) * 100
+		d.HasGPUActive = true
+	}
+	if v, ok := m["thermal_pressure"].(string); ok {
+		d.ThermalState = v
+	}
+	if v, ok := plistNum(m, "battery", "percent_charge"); ok {
+		d.BatteryPct = int(v)
+	}
+	if v, ok := plistNum(m, "smc", "fan"); ok {
+		d.FanRPM = []float64{v}
+	}
+	if v, ok := plistNum(m, "network", "ibyte_rate"); ok {
+		d.NetIn = v
+	}
+	if v, ok := plistNum(m, "network", "obyte_rate"); ok {
+		d.NetOut = v
+	}
+	if v, ok := plistNum(m, "disk", "rbytes_per_s"); ok {
+		d.DiskRead = v
+	}
+	if v, ok := plistNum(m, "disk", "wbytes_per_s"); ok {
+		d.DiskWrite = v
+	}
+}
@@ -0,0 +1,344 @@
+// Package power collects power readings from powermetrics, ioreg and sysfs
+// into a shared PowerData, and keeps session statistics over them.
+package power
+
+import (
+	"io"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// PowerData is the latest reading from every source, shared between the
+// sources that update it and the outputs that read it.
+type PowerData struct {
+	readings
+
+	// Guards the readings. Sources' updates are applied under the write
+	// lock; readers take the read lock, or a Snapshot.
+	mu sync.RWMutex
+}
+
+// readings are PowerData's fields, in the units the sources report. Where
+// their methods say the caller holds the lock, that's on a PowerData; a
+// snapshot needs none.
+type readings struct {
+	// Live from powermetrics (1s updates)
+	CPUPower     float64
+	GPUPower     float64
+	ANEPower     float64
+	PackagePower float64
+	BatteryPct   int
+	FanRPM       []float64 // one per fan; only with the smc sampler
+
+	// CPU power by core type, summed over clusters; zero on chips that
+	// don't report per-cluster power
+	PCorePower float64
+	ECorePower float64
+	clusters   map[string]float64 // by cluster name, e.g. "P0", "E"
+
+	// GPU utilization, when the gpu_power sampler reports it
+	GPUActive    float64 // percent of time active
+	HasGPUActive bool
+
+	// From ioreg (~30s updates, polled every --ioreg-interval)
+	ChargerWatts   int
+	ChargerVoltage int
+	ChargerCurrent int
+	BatteryVoltage int
+	BatteryAmps    int
+	Temperature    int
+	SystemLoad     int // mW drawn by the machine, where ioreg reports it
+
+	// Charge in percent with sub-percent resolution, from the raw
+	// capacities; zero when unknown. BatteryPct stays what's shown.
+	BatteryLevel float64
+
+	// Battery wear; capacities in mAh, zero when unknown
+	CycleCount     int
+	DesignCapacity int
+	MaxCapacity    int
+	IsCharging     bool
+	OnAC           bool
+
+	// ioreg's FullyCharged, when it reports one
+	FullyCharged    bool
+	HasFullyCharged bool
+
+	// Every power source ioreg reported, in its order. The charger and
+	// battery fields above mirror the first; the rest, such as an external
+	// battery pack, are only here.
+	Batteries []Battery
+
+	// Adapter identity from ioreg; empty/zero when unknown
+	AdapterName       string
+	AdapterRatedWatts int
+	AdapterPort       string // e.g. "USB-C 2", on Macs that say
+
+	// I/O rates in bytes/s; only with the network and disk samplers
+	NetIn     float64
+	NetOut    float64
+	DiskRead  float64
+	DiskWrite float64
+
+	// macOS thermal pressure level (Nominal, Fair, Serious, Critical);
+	// empty until powermetrics reports one
+	ThermalState string
+
+	// macOS Low Power Mode, from pmset
+	LowPowerMode bool
+
+	// The process --watch-pid follows, and its fraction of the CPU time
+	// in use; ProcessGone once it has exited
+	WatchPID     int
+	ProcessName  string
+	ProcessShare float64
+	ProcessGone  bool
+
+	// Set on Intel Macs, which have no ANE or per-rail power
+	Intel bool
+
+	// Set when powermetrics can't run and only ioreg data is available
+	NoSilicon bool
+
+	// Set while a crashed powermetrics is being restarted
+	Reconnecting bool
+
+	// Samples published so far; if it stops climbing, sampling has stalled
+	Samples int
+
+	// OnAC and IsCharging as the display shows them, once they've held
+	// for DebounceReads reads; see Steady
+	steadyAC, steadyCharging bool
+	steadySet                bool
+	pending                  int
+
+	// When each ioreg pattern last matched, keyed like the patterns, plus
+	// "ioreg" for the last successful poll
+	Updated map[string]time.Time
+}
+
+// PowerDataSnapshot is a copy of PowerData's readings, made under the read
+// lock once, plus the main ones in display units. It needs no locking.
+type PowerDataSnapshot struct {
+	readings
+
+	CPUWatts     float64
+	GPUWatts     float64
+	ANEWatts     float64
+	PackageWatts float64
+	BatteryVolts float64
+	ChargerVolts float64
+	ChargerAmps  float64
+	TempC        float64
+}
+
+// Snapshot takes the read lock and copies out the current readings.
+func (p *PowerData) Snapshot() PowerDataSnapshot {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	s := PowerDataSnapshot{readings: p.readings}
+	// The snapshot gets its own copies of the slices and maps, which
+	// sources may update in place
+	s.FanRPM = slices.Clone(p.FanRPM)
+	s.Batteries = slices.Clone(p.Batteries)
+	s.clusters = maps.Clone(p.clusters)
+	s.Updated = maps.Clone(p.Updated)
+
+	s.CPUWatts = p.CPUPower / 1000
+	s.GPUWatts = p.GPUPower / 1000
+	s.ANEWatts = p.ANEPower / 1000
+	s.PackageWatts = p.PackagePower / 1000
+	s.BatteryVolts = float64(p.BatteryVoltage) / 1000
+	s.ChargerVolts = float64(p.ChargerVoltage) / 1000
+	s.ChargerAmps = float64(p.ChargerCurrent) / 1000
+	s.TempC = float64(p.Temperature) / 100
+	return s
+}
+
+// ProcessWatts estimates the watched process's power as its share of the
+// CPU's, or 0 when there's no process being watched. The caller holds the
+// lock.
+func (p *readings) ProcessWatts() float64 {
+	if p.WatchPID == 0 || p.ProcessGone {
+		return 0
+	}
+	return p.CPUPower / 1000 * p.ProcessShare
+}
+
+// ChargeLimit is where macOS's optimized charging holds the battery.
+const ChargeLimit = 80
+
+// ChargeHeld reports whether charging is paused at ChargeLimit rather than
+// finished: on AC, not charging, within a few points of the limit, and not
+// fully charged if ioreg says. onAC and charging are as shown, from
+// Steady. The caller holds the lock.
+func (p *readings) ChargeHeld(onAC, charging bool) bool {
+	if !onAC || charging || p.HasFullyCharged && p.FullyCharged {
+		return false
+	}
+	return p.BatteryPct >= ChargeLimit-3 && p.BatteryPct <= ChargeLimit+3
+}
+
+// BatteryWatts is the battery's net power: positive while it gains charge,
+// negative while it loses it. The caller holds the lock.
+func (p *readings) BatteryWatts() float64 {
+	return float64(p.BatteryVoltage) / 1000 * float64(p.BatteryAmps) / 1000
+}
+
+// EnergyWh estimates the energy left in the battery and the most it holds
+// now, in watt-hours: the full charge capacity at the present voltage, and
+// that scaled by the charge level. Both are zero when ioreg hasn't reported
+// the capacity. The caller holds the lock.
+func (p *readings) EnergyWh() (remaining, full float64) {
+	if p.MaxCapacity == 0 || p.BatteryVoltage == 0 {
+		return 0, 0
+	}
+	level := p.BatteryLevel
+	if level == 0 {
+		level = float64(p.BatteryPct)
+	}
+	full = float64(p.MaxCapacity) * float64(p.BatteryVoltage) / 1e6
+	return full * level / 100, full
+}
+
+// Battery is one power source from ioreg, in the same units as PowerData.
+type Battery struct {
+	Name         string
+	Percent      int // 0 when unknown
+	Voltage      int // mV
+	Amps         int // mA, negative while discharging
+	Temperature  int // hundredths of °C
+	ChargerWatts int
+	IsCharging   bool
+	OnAC         bool
+}
+
+// Watts is the battery's net power, as for PowerData.BatteryWatts.
+func (b Battery) Watts() float64 {
+	return float64(b.Voltage) / 1000 * float64(b.Amps) / 1000
+}
+
+// DebugLog reports parse problems. It discards them unless the caller
+// points it somewhere.
+var DebugLog = log.New(io.Discard, "", log.LstdFlags)
+
+// StaleAfter is how long an ioreg field can go without matching before it's
+// flagged as stale.
+var StaleAfter = 30 * time.Second
+
+// DebounceReads is how many reads in a row a change of power source must
+// last before the display follows it; 1 follows every read.
+var DebounceReads = 1
+
+// settle counts one read of OnAC and IsCharging towards changing what
+// Steady reports. Sources call it after each read, holding the lock.
+func (p *readings) settle() {
+	if !p.steadySet || (p.OnAC == p.steadyAC && p.IsCharging == p.steadyCharging) {
+		p.steadyAC, p.steadyCharging, p.steadySet = p.OnAC, p.IsCharging, true
+		p.pending = 0
+		return
+	}
+	if p.pending++; p.pending >= DebounceReads {
+		p.steadyAC, p.steadyCharging = p.OnAC, p.IsCharging
+		p.pending = 0
+	}
+}
+
+// Steady reports OnAC and IsCharging debounced, so a marginal charger
+// doesn't flip the layout back and forth. Sources that don't settle their
+// readings pass them through as is. The caller holds the lock.
+func (p *readings) Steady() (onAC, charging bool) {
+	if !p.steadySet {
+		return p.OnAC, p.IsCharging
+	}
+	return p.steadyAC, p.steadyCharging
+}
+
+// Stale reports whether an ioreg field has stopped updating. Nothing is
+// stale before ioreg has been polled, or on sources that don't use it.
+// Callers must hold the read lock.
+func (p *readings) Stale(key string) bool {
+	if p.Updated["ioreg"].IsZero() {
+		return false
+	}
+	t := p.Updated[key]
+	return t.IsZero() || time.Since(t) > StaleAfter
+}
+
+// Sample is a point-in-time copy of PowerData converted to display units.
+type Sample struct {
+	Time         time.Time `json:"time"`
+	CPUWatts     float64   `json:"cpu_w"`
+	GPUWatts     float64   `json:"gpu_w"`
+	ANEWatts     float64   `json:"ane_w"`
+	PackageWatts float64   `json:"package_w"`
+	PCoreWatts   float64   `json:"pcore_w,omitempty"`
+	ECoreWatts   float64   `json:"ecore_w,omitempty"`
+	BatteryPct   int       `json:"battery_pct"`
+	BatteryLevel float64   `json:"battery_level"` // BatteryPct, finer where the hardware allows
+	ChargerWatts int       `json:"charger_w"`
+	BatteryVolts float64   `json:"battery_v"`
+	BatteryAmps  float64   `json:"battery_a"`
+	BatteryWatts float64   `json:"battery_w"`
+	TempC        float64   `json:"temp_c"`
+	IsCharging   bool      `json:"is_charging"`
+	OnAC         bool      `json:"on_ac"`
+	ThermalState string    `json:"thermal,omitempty"`
+	LowPowerMode bool      `json:"low_power_mode,omitempty"`
+	ProcessWatts float64   `json:"process_w,omitempty"` // --watch-pid's estimate
+	FanRPM       []float64 `json:"fan_rpm,omitempty"`
+}
+
+// Sample takes the read lock and copies out the current readings.
+func (p *PowerData) Sample() Sample {
+	return p.Snapshot().Sample()
+}
+
+// Sample converts the snapshot to a Sample.
+func (s PowerDataSnapshot) Sample() Sample {
+	level := s.BatteryLevel
+	if level == 0 {
+		level = float64(s.BatteryPct)
+	}
+	return Sample{
+		Time:         time.Now(),
+		CPUWatts:     s.CPUWatts,
+		GPUWatts:     s.GPUWatts,
+		ANEWatts:     s.ANEWatts,
+		PackageWatts: s.PackageWatts,
+		PCoreWatts:   s.PCorePower / 1000,
+		ECoreWatts:   s.ECorePower / 1000,
+		BatteryPct:   s.BatteryPct,
+		BatteryLevel: level,
+		ChargerWatts: s.ChargerWatts,
+		BatteryVolts: s.BatteryVolts,
+		BatteryAmps:  float64(s.BatteryAmps) / 1000,
+		BatteryWatts: s.BatteryWatts(),
+		TempC:        s.TempC,
+		IsCharging:   s.IsCharging,
+		OnAC:         s.OnAC,
+		ThermalState: s.ThermalState,
+		LowPowerMode: s.LowPowerMode,
+		ProcessWatts: s.ProcessWatts(),
+		FanRPM:       s.FanRPM,
+	}
+}
+
+// Fahrenheit converts a temperature in °C to °F.
+func Fahrenheit(c float64) float64 {
+	return c*9/5 + 32
+}
+
+// Apply merges an update into p under the write lock.
+func (p *PowerData) Apply(u Update) {
+	if u.Apply == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	u.Apply(p)
+}
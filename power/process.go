@@ -0,0 +1,68 @@
+package power
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProcessSource follows one process's share of the CPU time in use, from
+// which the display estimates its share of CPU power. It's a proportional
+// guess, not a measurement, but good enough to compare workloads. Run
+// returns once the process has exited.
+type ProcessSource struct {
+	PID   int
+	Every time.Duration
+}
+
+func (s *ProcessSource) Run(ctx context.Context, ch chan<- Update) error {
+	for {
+		out, err := exec.Command("ps", "-A", "-o", "pid=,%cpu=,comm=").Output()
+		if err != nil {
+			return err
+		}
+		name, share, found := processShare(out, s.PID)
+		if !found {
+			ch <- Update{Apply: func(d *PowerData) { d.ProcessGone = true }}
+			return nil
+		}
+		ch <- Update{Apply: func(d *PowerData) {
+			d.WatchPID, d.ProcessName, d.ProcessShare = s.PID, name, share
+		}}
+		if !sleep(ctx, s.Every) {
+			return nil
+		}
+	}
+}
+
+// processShare picks pid out of ps output and works out its fraction of
+// the %cpu of every process listed.
+func processShare(out []byte, pid int) (name string, share float64, found bool) {
+	var mine, total float64
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		p, err1 := strconv.Atoi(fields[0])
+		pct, err2 := strconv.ParseFloat(fields[1], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		total += pct
+		if p == pid {
+			// macOS lists the whole path, which may have spaces
+			name, mine, found = filepath.Base(strings.Join(fields[2:], " ")), pct, true
+		}
+	}
+	if total > 0 {
+		share = mine / total
+	}
+	return name, share, found
+}
@@ -0,0 +1,74 @@
+package power
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Recorder writes a recording of a powermetrics session for --replay.
+// Recordings hold one raw powermetrics line per row, prefixed with the
+// RFC 3339 time it was read and a tab.
+type Recorder struct {
+	f *os.File
+}
+
+// CreateRecording creates the recording at path, truncating any that's
+// already there.
+func CreateRecording(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{f: f}, nil
+}
+
+// Line records one line of powermetrics output, stamped with the time now.
+func (r *Recorder) Line(text string) error {
+	_, err := fmt.Fprintf(r.f, "%s\t%s\n", time.Now().Format(time.RFC3339Nano), text)
+	return err
+}
+
+// Close closes the recording file.
+func (r *Recorder) Close() error {
+	return r.f.Close()
+}
+
+// OpenReplay returns a reader that yields the powermetrics lines of a
+// recording, pausing between them so samples arrive with roughly the
+// original timing.
+func OpenReplay(path string) (io.Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		var last time.Time
+		for scanner.Scan() {
+			stamp, text, ok := strings.Cut(scanner.Text(), "\t")
+			if !ok {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339Nano, stamp)
+			if err != nil {
+				continue
+			}
+			if !last.IsZero() {
+				time.Sleep(t.Sub(last))
+			}
+			last = t
+			if _, err := fmt.Fprintln(pw, text); err != nil {
+				return
+			}
+		}
+		pw.CloseWithError(scanner.Err())
+	}()
+	return pr, nil
+}
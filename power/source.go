@@ -0,0 +1,658 @@
+package power
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Update is a reading from a PowerSource. Apply merges it into the shared
+// PowerData and is called with the write lock held. Tick marks the end of a
+// complete sample, when outputs should refresh.
+type Update struct {
+	Apply func(*PowerData)
+	Tick  bool
+}
+
+// A PowerSource produces readings. Run sends updates on ch until the
+// source is exhausted or fails, or ctx is cancelled, then returns.
+type PowerSource interface {
+	Run(ctx context.Context, ch chan<- Update) error
+}
+
+// sleep waits for d, or reports false as soon as ctx is cancelled.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Config selects how the live sources sample.
+type Config struct {
+	Interval      time.Duration // powermetrics/sysfs sample period
+	IoregInterval time.Duration
+	IoregEase     bool      // interpolate battery readings between ioreg runs
+	Plist         bool      // read powermetrics' plist output rather than its text
+	SudoAskpass   string    // program sudo asks for the password, for running headless
+	Fans          bool      // also sample fan speeds
+	IO            bool      // also sample network and disk activity
+	Samplers      []string  // further powermetrics samplers to run
+	Recorder      *Recorder // if set, raw powermetrics output is saved here
+}
+
+// ErrNoPowermetrics is returned when powermetrics exits without producing a
+// sample, which almost always means sudo was denied.
+var ErrNoPowermetrics = errors.New("powermetrics unavailable (needs sudo)")
+
+// PowermetricsSource parses powermetrics output, text or plist. It
+// launches powermetrics itself unless Input is set, in which case it reads
+// from that instead (used by --replay), telling the formats apart as it
+// goes.
+type PowermetricsSource struct {
+	Interval int    // milliseconds
+	Samplers string // comma-separated, as passed to --samplers
+	Intel    bool   // Intel Mac: package power only, no ANE
+	Plist    bool   // launch with -f plist rather than -f text
+	Askpass  string // program sudo runs for the password, if any
+	Input    io.Reader
+	Recorder *Recorder
+
+	cmd *exec.Cmd
+}
+
+func (s *PowermetricsSource) Run(ctx context.Context, ch chan<- Update) error {
+	input := s.Input
+	if input == nil {
+		format := "text"
+		if s.Plist {
+			format = "plist"
+		}
+		s.cmd = s.command("powermetrics",
+			"--samplers", s.Samplers,
+			"-i", strconv.Itoa(s.Interval),
+			"-f", format)
+
+		stdout, err := s.cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := s.cmd.Start(); err != nil {
+			return fmt.Errorf("%w: %v", ErrNoPowermetrics, err)
+		}
+		// Whichever way this returns, powermetrics (or sudo) is killed
+		// and reaped rather than left behind for the next restart
+		defer func() {
+			if s.cmd.ProcessState == nil {
+				s.cmd.Process.Kill()
+				s.cmd.Wait()
+			}
+		}()
+		input = stdout
+	}
+	// Cancelling kills powermetrics, or closes a replay, so the read
+	// waiting on it returns
+	cmd := s.cmd
+	defer context.AfterFunc(ctx, func() {
+		if cmd != nil {
+			cmd.Process.Kill()
+		} else if c, ok := input.(io.Closer); ok {
+			c.Close()
+		}
+	})()
+
+	if s.Intel {
+		ch <- Update{Apply: func(d *PowerData) { d.Intel = true }}
+	}
+
+	scanner := bufio.NewScanner(input)
+	started := false
+	var plist []string // lines of the plist sample being read
+
+	for scanner.Scan() {
+		text := strings.TrimLeft(scanner.Text(), "\x00")
+		if s.Recorder != nil {
+			if err := s.Recorder.Line(text); err != nil {
+				return fmt.Errorf("writing recording: %w", err)
+			}
+		}
+
+		// A plist sample is complete, and so ticks, at its closing tag
+		if plist != nil || isPlistLine(text) {
+			plist = append(plist, text)
+			if strings.TrimSpace(text) != "</plist>" {
+				continue
+			}
+			m, err := parsePlist(strings.Join(plist, "\n"))
+			plist = nil
+			if err != nil {
+				DebugLog.Printf("powermetrics plist: %v", err)
+				continue
+			}
+			started = true
+			ch <- Update{
+				Apply: func(d *PowerData) { applyPlist(m, d) },
+				Tick:  true,
+			}
+			continue
+		}
+
+		// Sample separators are "*** ...", section headers "**** ...".
+		// The first separator only opens the first sample.
+		tick := false
+		if strings.HasPrefix(text, "*** ") {
+			tick = started
+			started = true
+		}
+
+		ch <- Update{
+			Apply: func(d *PowerData) { parseLine(text, d) },
+			Tick:  tick,
+		}
+	}
+	if ctx.Err() != nil {
+		if s.cmd != nil {
+			s.cmd.Wait()
+		}
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if s.cmd == nil {
+		return nil
+	}
+	if !started {
+		s.cmd.Wait()
+		return ErrNoPowermetrics
+	}
+	if err := s.cmd.Wait(); err != nil {
+		return fmt.Errorf("powermetrics: %w", err)
+	}
+	return nil
+}
+
+// command runs a program as root: directly if powermon already is, and
+// otherwise through sudo, which gets the password from Askpass if it's set
+// rather than prompting on the terminal.
+func (s *PowermetricsSource) command(name string, args ...string) *exec.Cmd {
+	if os.Geteuid() == 0 {
+		return exec.Command(name, args...)
+	}
+	if s.Askpass == "" {
+		return exec.Command("sudo", append([]string{name}, args...)...)
+	}
+	cmd := exec.Command("sudo", append([]string{"-A", name}, args...)...)
+	cmd.Env = append(os.Environ(), "SUDO_ASKPASS="+s.Askpass)
+	return cmd
+}
+
+// Stop kills the powermetrics process, if one was launched.
+func (s *PowermetricsSource) Stop() {
+	if s.cmd != nil && s.cmd.Process != nil {
+		s.cmd.Process.Kill()
+	}
+}
+
+// Regex patterns for powermetrics text output. Readings are matched with
+// either decimal separator, and power in mW or W, since both vary with the
+// locale and macOS release.
+const (
+	numPat   = `([\d.,]+)`
+	powerPat = numPat + `\s*(m?W)\b`
+)
+
+var (
+	cpuPowerRe   = regexp.MustCompile(`CPU Power:\s+` + powerPat)
+	gpuPowerRe   = regexp.MustCompile(`GPU Power:\s+` + powerPat)
+	anePowerRe   = regexp.MustCompile(`ANE Power:\s+` + powerPat)
+	packageRe    = regexp.MustCompile(`Combined Power \(CPU \+ GPU \+ ANE\):\s+` + powerPat)
+	batteryPctRe = regexp.MustCompile(`percent_charge:\s+(\d+)`)
+	fanRe        = regexp.MustCompile(`^Fan(?:\s+(\d+))?:\s+` + numPat + `\s+rpm`)
+	gpuActiveRe  = regexp.MustCompile(`^GPU (?:HW )?active residency:\s+` + numPat + `%`)
+	thermalRe    = regexp.MustCompile(`^Current pressure level:\s+(\w+)`)
+	netRe        = regexp.MustCompile(`^(in|out):\s+[\d.,]+ packets/s,\s+` + numPat + ` bytes/s`)
+	diskRe       = regexp.MustCompile(`^(read|write):\s+[\d.,]+ ops/s\s+` + numPat + ` KBytes/s`)
+	clusterRe    = regexp.MustCompile(`^((E|P)\d*)-Cluster Power:\s+` + powerPat)
+
+	// Intel Macs report package power in watts, and nothing per rail
+	intelPackageRe = regexp.MustCompile(`^(?:Intel energy model derived package power \(CPUs\+GT\+SA\)|Package Power):\s+` + powerPat)
+)
+
+// number parses a reading matched by numPat. Its last separator, dot or
+// comma, is the decimal point and any others group thousands; powermetrics
+// doesn't group, so a lone comma is a decimal comma.
+func number(s string) float64 {
+	if i := strings.LastIndexAny(s, ".,"); i >= 0 {
+		s = strings.NewReplacer(".", "", ",", "").Replace(s[:i]) + "." + s[i+1:]
+	}
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
+
+// milliwatts converts a reading matched by powerPat to mW, the unit
+// PowerData keeps. That's powermetrics' usual unit, and PowerData's rail
+// fields are public API in mW, so W readings are brought to it rather than
+// the other way round; Sample is where everything comes out in watts.
+func milliwatts(v, unit string) float64 {
+	if unit == "W" {
+		return number(v) * 1000
+	}
+	return number(v)
+}
+
+// parseLine merges whatever reading one line of powermetrics output carries
+// into d. Lines it doesn't recognize leave d untouched.
+func parseLine(text string, d *PowerData) {
+	if m := cpuPowerRe.FindStringSubmatch(text); m != nil {
+		d.CPUPower = milliwatts(m[1], m[2])
+	}
+	if m := gpuPowerRe.FindStringSubmatch(text); m != nil {
+		d.GPUPower = milliwatts(m[1], m[2])
+	}
+	if m := anePowerRe.FindStringSubmatch(text); m != nil {
+		d.ANEPower = milliwatts(m[1], m[2])
+	}
+	if m := packageRe.FindStringSubmatch(text); m != nil {
+		d.PackagePower = milliwatts(m[1], m[2])
+	}
+	if m := batteryPctRe.FindStringSubmatch(text); m != nil {
+		d.BatteryPct, _ = strconv.Atoi(m[1])
+	}
+	if m := fanRe.FindStringSubmatch(text); m != nil {
+		i, _ := strconv.Atoi(m[1]) // unnumbered means the only fan
+		for len(d.FanRPM) <= i {
+			d.FanRPM = append(d.FanRPM, 0)
+		}
+		d.FanRPM[i] = number(m[2])
+	}
+	if m := intelPackageRe.FindStringSubmatch(text); m != nil {
+		d.PackagePower = milliwatts(m[1], m[2])
+		d.Intel = true // also catches replays of Intel recordings
+	}
+	if m := netRe.FindStringSubmatch(text); m != nil {
+		v := number(m[2])
+		if m[1] == "in" {
+			d.NetIn = v
+		} else {
+			d.NetOut = v
+		}
+	}
+	if m := diskRe.FindStringSubmatch(text); m != nil {
+		v := number(m[2])
+		if m[1] == "read" {
+			d.DiskRead = v * 1024
+		} else {
+			d.DiskWrite = v * 1024
+		}
+	}
+	if m := thermalRe.FindStringSubmatch(text); m != nil {
+		d.ThermalState = m[1]
+	}
+	if m := gpuActiveRe.FindStringSubmatch(text); m != nil {
+		d.GPUActive = number(m[1])
+		d.HasGPUActive = true
+	}
+	if m := clusterRe.FindStringSubmatch(text); m != nil {
+		d.setCluster(m[1], milliwatts(m[3], m[4]))
+	}
+}
+
+// setCluster records one cluster's power and re-totals the P and E cores.
+// Pro/Max chips split the P-cores over clusters P0, P1, ...
+func (d *PowerData) setCluster(name string, mw float64) {
+	if d.clusters == nil {
+		d.clusters = map[string]float64{}
+	}
+	d.clusters[name] = mw
+	d.PCorePower, d.ECorePower = 0, 0
+	for name, mw := range d.clusters {
+		if name[0] == 'P' {
+			d.PCorePower += mw
+		} else {
+			d.ECorePower += mw
+		}
+	}
+}
+
+// ioregPatterns pick the charger and battery fields out of ioreg's output,
+// by Bounds key where they're numeric.
+var ioregPatterns = map[string]*regexp.Regexp{
+	"watts":       regexp.MustCompile(`"Watts"=(\d+)`),
+	"adapterV":    regexp.MustCompile(`"AdapterVoltage"=(\d+)`),
+	"adapterA":    regexp.MustCompile(`"(?:AppleRaw)?AdapterDetails" = \(?\{[^}]*"Current"=(\d+)`),
+	"batteryV":    regexp.MustCompile(`"AppleRawBatteryVoltage" = (\d+)`),
+	"batteryA":    regexp.MustCompile(`"Amperage" = (-?\d+)`),
+	"temp":        regexp.MustCompile(`"Temperature" = (\d+)`),
+	"charging":    regexp.MustCompile(`"IsCharging" = (Yes|No)`),
+	"external":    regexp.MustCompile(`"ExternalConnected" = (Yes|No)`),
+	"charged":     regexp.MustCompile(`"FullyCharged" = (Yes|No)`),
+	"cycles":      regexp.MustCompile(`"CycleCount" = (\d+)`),
+	"designCap":   regexp.MustCompile(`"DesignCapacity" = (\d+)`),
+	"maxCap":      regexp.MustCompile(`"AppleRawMaxCapacity" = (\d+)`),
+	"rawCap":      regexp.MustCompile(`"AppleRawCurrentCapacity" = (\d+)`),
+	"capacity":    regexp.MustCompile(`"CurrentCapacity" = (\d+)`),
+	"fullCap":     regexp.MustCompile(`"MaxCapacity" = (\d+)`),
+	"systemLoad":  regexp.MustCompile(`"PowerTelemetryData" = \{[^}]*"SystemLoad"=(\d+)`),
+	"adapterName": regexp.MustCompile(`"AdapterDetails" = \{[^}]*"Name"="([^"]*)"`),
+	"adapterPort": regexp.MustCompile(`"AdapterDetails" = \{[^}]*"PortDescription"="([^"]*)"`),
+}
+
+// IoregKeys lists the fields searched for in ioreg's output, sorted, by
+// the keys PowerData.Updated uses.
+func IoregKeys() []string {
+	return slices.Sorted(maps.Keys(ioregPatterns))
+}
+
+var (
+	ratedRe  = regexp.MustCompile(`^(\d+)W`)
+	pdMenuRe = regexp.MustCompile(`"MaxVoltage"=(\d+),"MaxCurrent"=(\d+)`)
+)
+
+// easedKeys are the readings that drift rather than jump, so they can be
+// interpolated between ioreg runs.
+var easedKeys = []string{"batteryV", "batteryA", "temp", "systemLoad"}
+
+// ioregField is where the reading for a numeric key lives, or nil for the
+// ones that only feed Batteries.
+func ioregField(d *PowerData, key string) *int {
+	switch key {
+	case "watts":
+		return &d.ChargerWatts
+	case "adapterV":
+		return &d.ChargerVoltage
+	case "adapterA":
+		return &d.ChargerCurrent
+	case "batteryV":
+		return &d.BatteryVoltage
+	case "batteryA":
+		return &d.BatteryAmps
+	case "temp":
+		return &d.Temperature
+	case "cycles":
+		return &d.CycleCount
+	case "designCap":
+		return &d.DesignCapacity
+	case "maxCap":
+		return &d.MaxCapacity
+	case "systemLoad":
+		return &d.SystemLoad
+	}
+	return nil
+}
+
+// ioregReading is what one run of ioreg reported.
+type ioregReading struct {
+	ints    map[string]int    // numeric fields within Bounds
+	strs    map[string]string // everything else that matched
+	matched []string          // every key that matched, in or out of bounds
+	rated   int               // adapter rating in watts, 0 if unknown
+}
+
+// battery summarizes the reading as one of PowerData's Batteries.
+func (r ioregReading) battery(name string) Battery {
+	b := Battery{
+		Name:         name,
+		Voltage:      r.ints["batteryV"],
+		Amps:         r.ints["batteryA"],
+		Temperature:  r.ints["temp"],
+		ChargerWatts: r.ints["watts"],
+		IsCharging:   r.strs["charging"] == "Yes",
+		OnAC:         r.strs["external"] == "Yes",
+	}
+	if full := r.ints["fullCap"]; full > 0 {
+		b.Percent = min(r.ints["capacity"]*100/full, 100)
+	}
+	return b
+}
+
+// ioregEntryRe starts each matched node; their children are indented.
+var ioregEntryRe = regexp.MustCompile(`(?m)^\+-o `)
+
+// splitIoreg separates ioreg's output into one entry per power source,
+// named after its node. Names are made unique.
+func splitIoreg(s string) (names, entries []string) {
+	seen := map[string]int{}
+	for _, entry := range ioregEntryRe.Split(s, -1)[1:] {
+		name, _, _ := strings.Cut(entry, " ")
+		if seen[name]++; seen[name] > 1 {
+			name = fmt.Sprintf("%s %d", name, seen[name])
+		}
+		names = append(names, name)
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return []string{""}, []string{s}
+	}
+	return names, entries
+}
+
+// ioregInt parses one of ioreg's integers. It prints them unsigned, so a
+// negative one, such as a discharging battery's current, comes out as its
+// 64-bit two's complement.
+func ioregInt(s string) (int, error) {
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return int(int64(u)), nil
+	}
+	return strconv.Atoi(s)
+}
+
+func parseIoreg(s string) ioregReading {
+	r := ioregReading{ints: map[string]int{}, strs: map[string]string{}}
+	for key, re := range ioregPatterns {
+		m := re.FindStringSubmatch(s)
+		if len(m) < 2 {
+			DebugLog.Printf("ioreg: no match for %s (%s)", key, re)
+			continue
+		}
+		r.matched = append(r.matched, key)
+		if _, numeric := Bounds[key]; !numeric {
+			r.strs[key] = m[1]
+			continue
+		}
+		// Only keep values within Bounds
+		v, err := ioregInt(m[1])
+		if err != nil {
+			DebugLog.Printf("ioreg: %s: %v", key, err)
+			continue
+		}
+		if b := Bounds[key]; v < b.Min || v > b.Max {
+			DebugLog.Printf("ioreg: rejected %s=%d, outside %d..%d", key, v, b.Min, b.Max)
+			continue
+		}
+		r.ints[key] = v
+	}
+
+	// Adapter names usually lead with the rating ("96W USB-C Power
+	// Adapter"); otherwise take the best USB-PD offer
+	if m := ratedRe.FindStringSubmatch(r.strs["adapterName"]); m != nil {
+		r.rated, _ = strconv.Atoi(m[1])
+	}
+	if r.rated == 0 {
+		for _, m := range pdMenuRe.FindAllStringSubmatch(s, -1) {
+			mv, _ := strconv.Atoi(m[1])
+			ma, _ := strconv.Atoi(m[2])
+			r.rated = max(r.rated, mv*ma/1000000)
+		}
+	}
+	return r
+}
+
+// portName turns a port description as ioreg gives it, such as
+// "Port-USB-C@2" or "Port-MagSafe 3@1", into "USB-C 2" or "MagSafe 3";
+// there's only the one MagSafe port. Macs that don't say which port the
+// adapter is on leave it empty.
+func portName(desc string) string {
+	name, n, _ := strings.Cut(strings.TrimPrefix(desc, "Port-"), "@")
+	if name == "USB-C" && n != "" {
+		return name + " " + n
+	}
+	return name
+}
+
+// IoregSource polls ioreg for charger/battery hardware data. It never
+// finishes on its own.
+//
+// Each poll launches a process, so a long Every is cheaper. Interpolate
+// keeps the display moving anyway: the battery voltage, current,
+// temperature and system load ease from one read to the next in steps of
+// Step, trailing the hardware by one interval. Everything else updates as
+// it's read.
+//
+// Read, if set, is called for ioreg's output in place of running it, so
+// dumps captured from other Macs can be fed through the same parsing.
+type IoregSource struct {
+	Every       time.Duration
+	Interpolate bool
+	Step        time.Duration
+	Read        func() ([]byte, error)
+}
+
+// runIoreg is the default Read.
+func runIoreg() ([]byte, error) {
+	return exec.Command("ioreg", "-rn", "AppleSmartBattery").Output()
+}
+
+func (s *IoregSource) Run(ctx context.Context, ch chan<- Update) error {
+	read := s.Read
+	if read == nil {
+		read = runIoreg
+	}
+	var prev ioregReading
+	for {
+		out, err := read()
+		if err != nil {
+			DebugLog.Printf("ioreg: %v", err)
+			if !sleep(ctx, s.Every) {
+				return nil
+			}
+			continue
+		}
+		// The first source is the machine's own battery
+		names, entries := splitIoreg(string(out))
+		r, now := parseIoreg(entries[0]), time.Now()
+		batteries := []Battery{r.battery(names[0])}
+		for i, entry := range entries[1:] {
+			batteries = append(batteries, parseIoreg(entry).battery(names[i+1]))
+		}
+
+		// Plugging in or out flips the current's sign, which is no time
+		// to ease
+		steps := 0
+		if s.Interpolate && s.Step > 0 && prev.ints != nil &&
+			r.strs["charging"] == prev.strs["charging"] && r.strs["external"] == prev.strs["external"] {
+			steps = int(s.Every / s.Step)
+		}
+		ch <- Update{Apply: func(d *PowerData) {
+			if d.Updated == nil {
+				d.Updated = map[string]time.Time{}
+			}
+			// Matches are timestamped so stale fields can be flagged
+			d.Updated["ioreg"] = now
+			for _, key := range r.matched {
+				d.Updated[key] = now
+			}
+			for key, v := range r.ints {
+				if steps > 0 && slices.Contains(easedKeys, key) {
+					continue
+				}
+				if f := ioregField(d, key); f != nil {
+					*f = v
+				}
+			}
+			if m, ok := r.strs["charging"]; ok {
+				d.IsCharging = m == "Yes"
+			}
+			if m, ok := r.strs["external"]; ok {
+				d.OnAC = m == "Yes"
+			}
+			if m, ok := r.strs["charged"]; ok {
+				d.FullyCharged, d.HasFullyCharged = m == "Yes", true
+			}
+			d.settle()
+			d.AdapterName, d.AdapterRatedWatts = r.strs["adapterName"], r.rated
+			d.AdapterPort = portName(r.strs["adapterPort"])
+			if c, full := r.ints["rawCap"], r.ints["maxCap"]; full > 0 && c > 0 {
+				d.BatteryLevel = min(float64(c)*100/float64(full), 100)
+			}
+			d.Batteries = batteries
+		}}
+
+		for i := 1; i <= steps; i++ {
+			if !sleep(ctx, s.Step) {
+				return nil
+			}
+			frac := float64(i) / float64(steps)
+			from, to := prev.ints, r.ints
+			ch <- Update{Apply: func(d *PowerData) {
+				for _, key := range easedKeys {
+					a, okA := from[key]
+					b, okB := to[key]
+					switch {
+					case okA && okB:
+						*ioregField(d, key) = a + int(math.Round(float64(b-a)*frac))
+					case okB:
+						*ioregField(d, key) = b
+					}
+				}
+			}}
+		}
+		prev = r
+		if !sleep(ctx, s.Every-time.Duration(steps)*s.Step) {
+			return nil
+		}
+	}
+}
+
+// PmsetSource polls pmset for whether Low Power Mode is on.
+type PmsetSource struct {
+	Every time.Duration
+}
+
+var lowPowerRe = regexp.MustCompile(`(?m)^\s*lowpowermode\s+(\d)`)
+
+func (s *PmsetSource) Run(ctx context.Context, ch chan<- Update) error {
+	for {
+		out, err := exec.Command("pmset", "-g").Output()
+		if err != nil {
+			DebugLog.Printf("pmset: %v", err)
+		} else if m := lowPowerRe.FindSubmatch(out); m != nil {
+			on := string(m[1]) == "1"
+			ch <- Update{Apply: func(d *PowerData) { d.LowPowerMode = on }}
+		}
+		if !sleep(ctx, s.Every) {
+			return nil
+		}
+	}
+}
+
+// FakeSource sends a fixed list of updates and finishes, standing in for
+// the hardware in tests.
+type FakeSource struct {
+	Updates []Update
+}
+
+func (s *FakeSource) Run(ctx context.Context, ch chan<- Update) error {
+	for _, u := range s.Updates {
+		if ctx.Err() != nil {
+			return nil
+		}
+		ch <- u
+	}
+	return nil
+}
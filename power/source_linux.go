@@ -0,0 +1,229 @@
+//go:build linux
+
+package power
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LiveSources reads the battery, thermal and RAPL interfaces in sysfs.
+// There's no powermetrics on Linux, so there's nothing to record.
+func LiveSources(cfg Config) (PowerSource, []PowerSource, error) {
+	if cfg.Recorder != nil {
+		return nil, nil, errors.New("--record needs powermetrics, which isn't available on Linux")
+	}
+	return &SysfsSource{Root: "/sys/class", Every: cfg.Interval}, nil, nil
+}
+
+// Preflight checks that there's a battery in sysfs to read, so a machine
+// without one is reported up front rather than once sampling has started.
+func Preflight(cfg Config) error {
+	if (&SysfsSource{Root: "/sys/class"}).findSupply("Battery") == "" {
+		return errors.New("no battery found in /sys/class/power_supply — powermon reads a laptop's battery; --replay plays back a recording without one")
+	}
+	return nil
+}
+
+// Authenticate has nothing to do: sysfs needs no root.
+func Authenticate(cfg Config) error {
+	return nil
+}
+
+// SysfsSource samples /sys/class/power_supply, /sys/class/hwmon and
+// /sys/class/powercap once per interval. Power rails come from RAPL energy
+// counters, differenced between samples, so the first sample only primes
+// them.
+type SysfsSource struct {
+	Root  string
+	Every time.Duration
+}
+
+// raplDomains maps RAPL zone names to the PowerData rail they populate.
+var raplDomains = map[string]func(d *PowerData, mw float64){
+	"package-0": func(d *PowerData, mw float64) { d.PackagePower = mw },
+	"core":      func(d *PowerData, mw float64) { d.CPUPower = mw },
+	"uncore":    func(d *PowerData, mw float64) { d.GPUPower = mw },
+}
+
+func (s *SysfsSource) Run(ctx context.Context, ch chan<- Update) error {
+	battery := s.findSupply("Battery")
+	if battery == "" {
+		return errors.New("no battery found in " + filepath.Join(s.Root, "power_supply"))
+	}
+
+	lastEnergy := map[string]int64{}
+	var lastTime time.Time
+	for {
+		now := time.Now()
+		energy := s.readRAPL()
+		rails := map[string]float64{}
+		if !lastTime.IsZero() {
+			secs := now.Sub(lastTime).Seconds()
+			for zone, uj := range energy {
+				prev, ok := lastEnergy[zone]
+				if !ok || secs <= 0 {
+					continue
+				}
+				delta := uj - prev
+				if delta < 0 {
+					// Counter wrapped
+					delta += s.raplMax(zone)
+				}
+				rails[zone] = float64(delta) / 1000 / secs // µJ/s → mW
+			}
+		}
+		primed := !lastTime.IsZero()
+		lastEnergy, lastTime = energy, now
+
+		pct, _ := readInt(filepath.Join(battery, "capacity"))
+		level := s.readLevel(battery)
+		uv, _ := readInt(filepath.Join(battery, "voltage_now"))
+		ua, haveCurrent := readInt(filepath.Join(battery, "current_now"))
+		uw, havePower := readInt(filepath.Join(battery, "power_now"))
+		status := readString(filepath.Join(battery, "status"))
+		if !haveCurrent && havePower && uv > 0 {
+			ua = uw * 1000000 / uv
+		}
+		// Drivers disagree on sign; status is authoritative
+		if ua < 0 {
+			ua = -ua
+		}
+		if status == "Discharging" {
+			ua = -ua
+		}
+		temp, haveTemp := s.readTemp()
+		fans := s.readFans()
+		onAC := s.mainsOnline()
+
+		ch <- Update{
+			Apply: func(d *PowerData) {
+				for zone, mw := range rails {
+					if set, ok := raplDomains[zone]; ok {
+						set(d, mw)
+					}
+				}
+				d.BatteryPct = int(pct)
+				d.BatteryLevel = level
+				d.BatteryVoltage = int(uv / 1000)
+				d.BatteryAmps = int(ua / 1000)
+				if haveTemp {
+					d.Temperature = int(temp / 10) // millidegrees → centidegrees
+				}
+				d.FanRPM = fans
+				d.IsCharging = status == "Charging"
+				d.OnAC = onAC
+				d.settle()
+			},
+			Tick: primed,
+		}
+		if !sleep(ctx, s.Every) {
+			return nil
+		}
+	}
+}
+
+// readLevel works out the battery's charge in percent from its charge or
+// energy counters, which are finer grained than capacity; 0 if it has
+// neither.
+func (s *SysfsSource) readLevel(battery string) float64 {
+	for _, kind := range []string{"charge", "energy"} {
+		now, okNow := readInt(filepath.Join(battery, kind+"_now"))
+		full, okFull := readInt(filepath.Join(battery, kind+"_full"))
+		if okNow && okFull && full > 0 {
+			return min(float64(now)*100/float64(full), 100)
+		}
+	}
+	return 0
+}
+
+// findSupply returns the first power supply directory of the given type.
+func (s *SysfsSource) findSupply(kind string) string {
+	dirs, _ := filepath.Glob(filepath.Join(s.Root, "power_supply", "*"))
+	for _, dir := range dirs {
+		if readString(filepath.Join(dir, "type")) == kind {
+			return dir
+		}
+	}
+	return ""
+}
+
+func (s *SysfsSource) mainsOnline() bool {
+	dirs, _ := filepath.Glob(filepath.Join(s.Root, "power_supply", "*"))
+	for _, dir := range dirs {
+		kind := readString(filepath.Join(dir, "type"))
+		if kind != "Mains" && kind != "USB" {
+			continue
+		}
+		if v, ok := readInt(filepath.Join(dir, "online")); ok && v == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// readTemp returns the first hwmon temperature, in millidegrees Celsius.
+func (s *SysfsSource) readTemp() (int64, bool) {
+	inputs, _ := filepath.Glob(filepath.Join(s.Root, "hwmon", "*", "temp1_input"))
+	for _, path := range inputs {
+		if v, ok := readInt(path); ok {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// readFans returns the speed of every hwmon fan, in RPM.
+func (s *SysfsSource) readFans() []float64 {
+	var rpms []float64
+	inputs, _ := filepath.Glob(filepath.Join(s.Root, "hwmon", "*", "fan*_input"))
+	for _, path := range inputs {
+		if v, ok := readInt(path); ok {
+			rpms = append(rpms, float64(v))
+		}
+	}
+	return rpms
+}
+
+// readRAPL returns the energy counters of every RAPL zone, in microjoules,
+// keyed by zone name.
+func (s *SysfsSource) readRAPL() map[string]int64 {
+	energy := map[string]int64{}
+	zones, _ := filepath.Glob(filepath.Join(s.Root, "powercap", "intel-rapl:*"))
+	for _, zone := range zones {
+		name := readString(filepath.Join(zone, "name"))
+		if v, ok := readInt(filepath.Join(zone, "energy_uj")); ok && name != "" {
+			energy[name] = v
+		}
+	}
+	return energy
+}
+
+func (s *SysfsSource) raplMax(name string) int64 {
+	zones, _ := filepath.Glob(filepath.Join(s.Root, "powercap", "intel-rapl:*"))
+	for _, zone := range zones {
+		if readString(filepath.Join(zone, "name")) == name {
+			v, _ := readInt(filepath.Join(zone, "max_energy_range_uj"))
+			return v
+		}
+	}
+	return 0
+}
+
+func readString(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func readInt(path string) (int64, bool) {
+	v, err := strconv.ParseInt(readString(path), 10, 64)
+	return v, err == nil
+}
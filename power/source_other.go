@@ -0,0 +1,90 @@
+//go:build !linux
+
+package power
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// Preflight checks that what LiveSources runs is there, so a missing
+// program is reported up front with what to do about it rather than as an
+// exec error once sampling has started. Whether sudo will actually let
+// powermetrics run is only known when it tries.
+func Preflight(cfg Config) error {
+	if runtime.GOOS != "darwin" {
+		return fmt.Errorf("powermon needs macOS (or Linux, where it reads sysfs), not %s; --replay plays back a recording anywhere", runtime.GOOS)
+	}
+	if _, err := exec.LookPath("ioreg"); err != nil {
+		return errors.New("ioreg not found — it ships with macOS in /usr/sbin; check that /usr/sbin is on your PATH")
+	}
+	if _, err := exec.LookPath("powermetrics"); err != nil {
+		return errors.New("powermetrics not found — it ships with macOS in /usr/bin; check that /usr/bin is on your PATH")
+	}
+	if os.Geteuid() == 0 {
+		return nil
+	}
+	if _, err := exec.LookPath("sudo"); err != nil {
+		return errors.New("sudo not found — powermetrics has to run as root, so run powermon as root instead")
+	}
+	if cfg.SudoAskpass != "" {
+		if _, err := exec.LookPath(cfg.SudoAskpass); err != nil {
+			return fmt.Errorf("--sudo-askpass: %v", err)
+		}
+	}
+	return nil
+}
+
+// Authenticate has sudo ask for the password now, on the terminal, so
+// powermetrics can start without a prompt once the live display has taken
+// over the screen and keyboard. It does nothing when powermon is root or
+// sudo gets the password from --sudo-askpass.
+func Authenticate(cfg Config) error {
+	if os.Geteuid() == 0 || cfg.SudoAskpass != "" {
+		return nil
+	}
+	cmd := exec.Command("sudo", "-v")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %v", ErrNoPowermetrics, err)
+	}
+	return nil
+}
+
+// LiveSources runs powermetrics for the silicon rails, alongside ioreg for
+// the charger and battery and pmset for Low Power Mode.
+func LiveSources(cfg Config) (PowerSource, []PowerSource, error) {
+	intel := !appleSilicon()
+	samplers := "cpu_power,gpu_power,thermal,battery"
+	if cfg.IO {
+		samplers += ",network,disk"
+	}
+	switch {
+	case cfg.Fans && intel:
+		samplers += ",smc"
+	case cfg.Fans:
+		DebugLog.Printf("powermetrics has no smc sampler on Apple Silicon; no fan speeds")
+	}
+	for _, name := range cfg.Samplers {
+		// smc only runs through Fans, which knows which Macs have it
+		if name != "smc" && !slices.Contains(strings.Split(samplers, ","), name) {
+			samplers += "," + name
+		}
+	}
+	pm := &PowermetricsSource{Interval: int(cfg.Interval.Milliseconds()), Samplers: samplers, Intel: intel, Plist: cfg.Plist, Askpass: cfg.SudoAskpass, Recorder: cfg.Recorder}
+	ioreg := &IoregSource{Every: cfg.IoregInterval, Interpolate: cfg.IoregEase, Step: cfg.Interval}
+	pmset := &PmsetSource{Every: cfg.IoregInterval}
+	return pm, []PowerSource{ioreg, pmset}, nil
+}
+
+// appleSilicon reports whether this is an Apple Silicon Mac, even when
+// running under Rosetta. Intel Macs don't have the sysctl at all.
+func appleSilicon() bool {
+	out, err := exec.Command("sysctl", "-n", "hw.optional.arm64").Output()
+	return err == nil && strings.TrimSpace(string(out)) == "1"
+}
@@ -0,0 +1,315 @@
+package power
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want readings
+	}{
+		// Lines as powermetrics prints them
+		{"cpu", "CPU Power: 1234 mW", readings{CPUPower: 1234}},
+		{"gpu", "GPU Power: 56 mW", readings{GPUPower: 56}},
+		{"cpu indented", "  CPU Power: 1234 mW", readings{CPUPower: 1234}},
+		{"ane", "ANE Power: 0 mW", readings{}},
+		{"package", "Combined Power (CPU + GPU + ANE): 1290 mW", readings{PackagePower: 1290}},
+		{"battery", "percent_charge: 87", readings{BatteryPct: 87}},
+		{"thermal", "Current pressure level: Nominal", readings{ThermalState: "Nominal"}},
+		{"gpu active", "GPU HW active residency:  12.34% (338 MHz: .01% 618 MHz:   0%)", readings{GPUActive: 12.34, HasGPUActive: true}},
+		{"gpu active intel", "GPU active residency:   3.10%", readings{GPUActive: 3.1, HasGPUActive: true}},
+		{"e cluster", "E-Cluster Power: 120 mW", readings{ECorePower: 120, clusters: map[string]float64{"E": 120}}},
+		{"p cluster", "P0-Cluster Power: 800 mW", readings{PCorePower: 800, clusters: map[string]float64{"P0": 800}}},
+		{"fan", "Fan: 1799.87 rpm", readings{FanRPM: []float64{1799.87}}},
+		{"second fan", "Fan 1: 2000 rpm", readings{FanRPM: []float64{0, 2000}}},
+		{"net in", "in:  12.34 packets/s, 5678.90 bytes/s", readings{NetIn: 5678.9}},
+		{"net out", "out: 3.00 packets/s, 250.00 bytes/s", readings{NetOut: 250}},
+		{"disk read", "read: 1.23 ops/s 45.50 KBytes/s", readings{DiskRead: 45.5 * 1024}},
+		{"disk write", "write: 7.00 ops/s 2.00 KBytes/s", readings{DiskWrite: 2 * 1024}},
+		{"intel package", "Intel energy model derived package power (CPUs+GT+SA): 3.21W", readings{PackagePower: 3210, Intel: true}},
+
+		// Decimal values
+		{"decimal mW", "CPU Power: 1234.56 mW", readings{CPUPower: 1234.56}},
+		{"decimal under 1", "GPU Power: 0.5 mW", readings{GPUPower: 0.5}},
+		{"decimal W", "Package Power: 12.5W", readings{PackagePower: 12500, Intel: true}},
+
+		// Missing or wrong units aren't power readings
+		{"no unit", "CPU Power: 1234", readings{}},
+		{"energy unit", "CPU Power: 1234 mWh", readings{}},
+		{"fan without rpm", "Fan: 1799.87", readings{}},
+
+		// Lines that don't match anything
+		{"blank", "", readings{}},
+		{"separator", "*** Sampled system activity (Wed Oct 15 10:00:00 2026 +0000) (1002.53ms elapsed) ***", readings{}},
+		{"section header", "**** Processor usage ****", readings{}},
+		{"frequency", "CPU 0 frequency: 1812 MHz", readings{}},
+		{"not available", "ANE Power: N/A", readings{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d PowerData
+			parseLine(tt.line, &d)
+			if !reflect.DeepEqual(d.readings, tt.want) {
+				t.Errorf("parseLine(%q)\n got %+v\nwant %+v", tt.line, d.readings, tt.want)
+			}
+		})
+	}
+}
+
+// A sample's lines build up the readings between them.
+func TestParseLineAccumulates(t *testing.T) {
+	var d PowerData
+	for _, line := range []string{
+		"E-Cluster Power: 100 mW",
+		"P0-Cluster Power: 300 mW",
+		"P1-Cluster Power: 200 mW",
+		"CPU Power: 600 mW",
+		"P0-Cluster Power: 250 mW",
+	} {
+		parseLine(line, &d)
+	}
+	if d.CPUPower != 600 || d.ECorePower != 100 || d.PCorePower != 450 {
+		t.Errorf("CPU %v, E %v, P %v; want 600, 100, 450", d.CPUPower, d.ECorePower, d.PCorePower)
+	}
+}
+
+func TestNumber(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"1234", 1234},
+		{"1.5", 1.5},
+		{"1,5", 1.5},
+		{"0,25", 0.25},
+		{".01", 0.01},
+		{"1,234.5", 1234.5},
+		{"1.234,5", 1234.5},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := number(tt.in); got != tt.want {
+			t.Errorf("number(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+// Power readings come out in mW whatever unit and decimal separator they
+// arrive in.
+func TestPowerUnits(t *testing.T) {
+	tests := []struct {
+		line string
+		want float64 // CPUPower, mW
+	}{
+		{"CPU Power: 1234 mW", 1234},
+		{"CPU Power: 500mW", 500},
+		{"CPU Power: 1234.5 mW", 1234.5},
+		{"CPU Power: 1234,5 mW", 1234.5},
+		{"CPU Power: 1.5 W", 1500},
+		{"CPU Power: 1,5 W", 1500},
+		{"CPU Power: 2W", 2000},
+		{"CPU Power: 0,25W", 250},
+		// Without a unit there's no telling W from mW, so it isn't read
+		{"CPU Power: 1234", 0},
+	}
+	for _, tt := range tests {
+		var d PowerData
+		parseLine(tt.line, &d)
+		if d.CPUPower != tt.want {
+			t.Errorf("parseLine(%q): CPUPower = %v mW, want %v", tt.line, d.CPUPower, tt.want)
+		}
+	}
+}
+
+func TestMilliwatts(t *testing.T) {
+	tests := []struct {
+		v, unit string
+		want    float64
+	}{
+		{"1234", "mW", 1234},
+		{"1,5", "W", 1500},
+		{"1.5", "W", 1500},
+		{"0,5", "mW", 0.5},
+	}
+	for _, tt := range tests {
+		if got := milliwatts(tt.v, tt.unit); got != tt.want {
+			t.Errorf("milliwatts(%q, %q) = %v, want %v", tt.v, tt.unit, got, tt.want)
+		}
+	}
+}
+
+// readIoreg runs an IoregSource over one ioreg dump and returns what its
+// first poll applied.
+func readIoreg(t *testing.T, out string) *PowerData {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan Update)
+	src := &IoregSource{Every: time.Hour, Read: func() ([]byte, error) { return []byte(out), nil }}
+	go src.Run(ctx, ch)
+	var d PowerData
+	d.Apply(<-ch)
+	return &d
+}
+
+func ioregDump(t *testing.T, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", "ioreg", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// ioregFields are the PowerData fields ioreg fills in.
+type ioregFields struct {
+	ChargerWatts, ChargerVoltage, ChargerCurrent int
+	BatteryVoltage, BatteryAmps, Temperature     int
+	CycleCount, DesignCapacity, MaxCapacity      int
+	SystemLoad                                   int
+	BatteryLevel                                 float64
+	OnAC, IsCharging                             bool
+	FullyCharged, HasFullyCharged                bool
+	AdapterName, AdapterPort                     string
+	AdapterRatedWatts                            int
+	Batteries                                    []Battery
+}
+
+func fieldsOf(d *PowerData) ioregFields {
+	return ioregFields{
+		d.ChargerWatts, d.ChargerVoltage, d.ChargerCurrent,
+		d.BatteryVoltage, d.BatteryAmps, d.Temperature,
+		d.CycleCount, d.DesignCapacity, d.MaxCapacity,
+		d.SystemLoad,
+		d.BatteryLevel,
+		d.OnAC, d.IsCharging,
+		d.FullyCharged, d.HasFullyCharged,
+		d.AdapterName, d.AdapterPort,
+		d.AdapterRatedWatts,
+		d.Batteries,
+	}
+}
+
+func TestIoregDumps(t *testing.T) {
+	tests := []struct {
+		dump string
+		want ioregFields
+	}{
+		{"apple-silicon.txt", ioregFields{
+			ChargerWatts: 94, ChargerVoltage: 20000, ChargerCurrent: 4700,
+			BatteryVoltage: 12796, BatteryAmps: 1834, Temperature: 3012,
+			CycleCount: 213, DesignCapacity: 6075, MaxCapacity: 5890,
+			SystemLoad:   36521,
+			BatteryLevel: 5123.0 * 100 / 5890,
+			OnAC:         true, IsCharging: true,
+			HasFullyCharged: true,
+			AdapterName:     "96W USB-C Power Adapter", AdapterPort: "USB-C 2",
+			AdapterRatedWatts: 96,
+			Batteries: []Battery{{Name: "AppleSmartBattery", Percent: 87, Voltage: 12796, Amps: 1834,
+				Temperature: 3012, ChargerWatts: 94, IsCharging: true, OnAC: true}},
+		}},
+		// Intel Macs report capacity in mAh rather than percent, have no
+		// power telemetry, and print the discharge current unsigned. Their
+		// LegacyBatteryInfo has a "Current" that isn't the charger's.
+		{"intel.txt", ioregFields{
+			BatteryVoltage: 12041, BatteryAmps: -1000, Temperature: 3104,
+			CycleCount: 402, DesignCapacity: 5103, MaxCapacity: 5091,
+			BatteryLevel:    3112.0 * 100 / 5091,
+			HasFullyCharged: true,
+			Batteries:       []Battery{{Name: "AppleSmartBattery", Percent: 61, Voltage: 12041, Amps: -1000, Temperature: 3104}},
+		}},
+		// On AC with the battery out, its zeroed readings fall outside
+		// Bounds and are dropped
+		{"no-battery.txt", ioregFields{
+			ChargerWatts: 61, ChargerVoltage: 20000, ChargerCurrent: 3000,
+			OnAC:            true,
+			HasFullyCharged: true,
+			AdapterName:     "61W USB-C Power Adapter", AdapterRatedWatts: 61,
+			Batteries: []Battery{{Name: "AppleSmartBattery", ChargerWatts: 61, OnAC: true}},
+		}},
+		// An external pack is a second source; the fields follow the first
+		{"battery-pack.txt", ioregFields{
+			ChargerWatts: 20, ChargerVoltage: 12000, ChargerCurrent: 1670,
+			BatteryVoltage: 11750, BatteryAmps: 1012, Temperature: 2950,
+			CycleCount: 88, DesignCapacity: 4382, MaxCapacity: 4465,
+			SystemLoad:   7740,
+			BatteryLevel: 2411.0 * 100 / 4465,
+			OnAC:         true, IsCharging: true,
+			HasFullyCharged: true,
+			AdapterName:     "MagSafe Battery Pack",
+			Batteries: []Battery{
+				{Name: "AppleSmartBattery", Percent: 54, Voltage: 11750, Amps: 1012, Temperature: 2950,
+					ChargerWatts: 20, IsCharging: true, OnAC: true},
+				{Name: "AppleSmartBattery 2", Percent: 72, Voltage: 7618, Amps: -2300, Temperature: 2710},
+			},
+		}},
+		// A Mac without a battery has no AppleSmartBattery to list
+		{"", ioregFields{Batteries: []Battery{{}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dump, func(t *testing.T) {
+			out := ""
+			if tt.dump != "" {
+				out = ioregDump(t, tt.dump)
+			}
+			d := readIoreg(t, out)
+			if got := fieldsOf(d); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\n got %+v\nwant %+v", got, tt.want)
+			}
+			if d.Updated["ioreg"].IsZero() {
+				t.Error("poll wasn't timestamped")
+			}
+		})
+	}
+}
+
+// Readings outside Bounds are dropped, though the field still counts as
+// matched, so it isn't flagged stale.
+func TestIoregBounds(t *testing.T) {
+	dump := ioregDump(t, "apple-silicon.txt")
+
+	glitched := strings.Replace(dump, `"Temperature" = 3012`, `"Temperature" = 65535`, 1)
+	d := readIoreg(t, glitched)
+	if d.Temperature != 0 {
+		t.Errorf("Temperature = %d, want the glitch dropped", d.Temperature)
+	}
+	if d.Updated["temp"].IsZero() {
+		t.Error("out-of-bounds temperature wasn't marked matched")
+	}
+	if d.BatteryVoltage != 12796 {
+		t.Errorf("BatteryVoltage = %d; a glitch in one field shouldn't drop others", d.BatteryVoltage)
+	}
+
+	// Bounds can be narrowed, as --bounds does
+	saved := Bounds["watts"]
+	defer func() { Bounds["watts"] = saved }()
+	Bounds["watts"] = Range{0, 60}
+	if d := readIoreg(t, dump); d.ChargerWatts != 0 {
+		t.Errorf("ChargerWatts = %d, want 94 W rejected by a 0..60 bound", d.ChargerWatts)
+	}
+}
+
+func TestIoregInt(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"1834", 1834},
+		{"0", 0},
+		{"-1000", -1000},
+		{"18446744073709550616", -1000},
+	}
+	for _, tt := range tests {
+		if got, err := ioregInt(tt.in); err != nil || got != tt.want {
+			t.Errorf("ioregInt(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
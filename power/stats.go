@@ -0,0 +1,478 @@
+package power
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Ring keeps the most recent values pushed into it, up to its capacity.
+type Ring struct {
+	vals []float64
+	next int
+	full bool
+}
+
+func NewRing(size int) *Ring {
+	return &Ring{vals: make([]float64, size)}
+}
+
+func (r *Ring) Push(v float64) {
+	r.vals[r.next] = v
+	r.next = (r.next + 1) % len(r.vals)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *Ring) Len() int {
+	if r.full {
+		return len(r.vals)
+	}
+	return r.next
+}
+
+// clone returns a copy of r, or nil if r is.
+func (r *Ring) clone() *Ring {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	c.vals = slices.Clone(r.vals)
+	return &c
+}
+
+// Values returns the buffered values, oldest first.
+func (r *Ring) Values() []float64 {
+	if !r.full {
+		return append([]float64(nil), r.vals[:r.next]...)
+	}
+	return append(append([]float64(nil), r.vals[r.next:]...), r.vals[:r.next]...)
+}
+
+type Summary struct {
+	Min, Avg, Max float64
+}
+
+// Summary reports min/avg/max over the buffer; ok is false when it's empty.
+func (r *Ring) Summary() (s Summary, ok bool) {
+	n := r.Len()
+	if n == 0 {
+		return s, false
+	}
+	s.Min, s.Max = r.vals[0], r.vals[0]
+	var sum float64
+	for _, v := range r.vals[:n] {
+		sum += v
+		if v < s.Min {
+			s.Min = v
+		}
+		if v > s.Max {
+			s.Max = v
+		}
+	}
+	s.Avg = sum / float64(n)
+	return s, true
+}
+
+// MeanStd reports the mean and standard deviation of the buffer.
+func (r *Ring) MeanStd() (mean, std float64) {
+	n := r.Len()
+	if n == 0 {
+		return 0, 0
+	}
+	for _, v := range r.vals[:n] {
+		mean += v
+	}
+	mean /= float64(n)
+	var sq float64
+	for _, v := range r.vals[:n] {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(n))
+}
+
+// Totals accumulates a value over a whole session.
+type Totals struct {
+	Min, Max, Sum float64
+	N             int
+}
+
+func (t *Totals) Add(v float64) {
+	if t.N == 0 || v < t.Min {
+		t.Min = v
+	}
+	if t.N == 0 || v > t.Max {
+		t.Max = v
+	}
+	t.Sum += v
+	t.N++
+}
+
+// Summary reports min/avg/max over the session; ok is false before the
+// first value.
+func (t *Totals) Summary() (s Summary, ok bool) {
+	if t.N == 0 {
+		return s, false
+	}
+	return Summary{Min: t.Min, Avg: t.Sum / float64(t.N), Max: t.Max}, true
+}
+
+// Trailing averages a value over the last Span of time.
+type Trailing struct {
+	Span  time.Duration
+	vals  []float64
+	times []time.Time
+	sum   float64
+}
+
+// Add records v as taken at t and drops whatever has aged out of the span.
+func (tr *Trailing) Add(t time.Time, v float64) {
+	tr.vals, tr.times = append(tr.vals, v), append(tr.times, t)
+	tr.sum += v
+	n := 0
+	for n < len(tr.times) && t.Sub(tr.times[n]) > tr.Span {
+		tr.sum -= tr.vals[n]
+		n++
+	}
+	tr.vals, tr.times = tr.vals[n:], tr.times[n:]
+}
+
+// clone returns a copy of tr that doesn't share its buffers.
+func (tr Trailing) clone() Trailing {
+	tr.vals, tr.times = slices.Clone(tr.vals), slices.Clone(tr.times)
+	return tr
+}
+
+// Avg is the mean over the span; ok is false when it's empty.
+func (tr *Trailing) Avg() (avg float64, ok bool) {
+	if len(tr.vals) == 0 {
+		return 0, false
+	}
+	return tr.sum / float64(len(tr.vals)), true
+}
+
+// Stats tracks a sliding window of silicon power samples, in watts, and the
+// energy used since startup.
+type Stats struct {
+	sessionStats
+
+	// Guards the fields; Record takes the write lock, and readers on
+	// other goroutines take a Snapshot.
+	mu sync.RWMutex
+}
+
+// sessionStats are Stats' fields, shared with its snapshots.
+type sessionStats struct {
+	CPU     *Ring
+	GPU     *Ring
+	Package *Ring
+
+	// Session energy in watt-hours: chip package power, and power drawn
+	// from the battery (charging doesn't count against it)
+	PackageWh float64
+	DrainWh   float64
+	last      time.Time
+
+	// When the session started, and the first battery reading
+	Start        time.Time
+	StartBattery int
+
+	// Each rail over the whole session, in watts
+	TotalCPU  Totals
+	TotalGPU  Totals
+	TotalANE  Totals
+	TotalChip Totals
+
+	// Package power over the last minute and quarter hour, for the trend
+	Chip1m  Trailing
+	Chip15m Trailing
+
+	// Battery level over the window, with when each was taken (Unix
+	// seconds), for the charge trend
+	level     *Ring
+	levelTime *Ring
+
+	// Highest package power seen this session, in watts, and when
+	PeakChip float64
+	PeakAt   time.Time
+
+	// Session sample counts per package power bucket; bucket i holds
+	// samples below HistogramEdges[i], the last everything above
+	Histogram [len(HistogramEdges) + 1]int
+
+	// Exponential moving averages of the rails, in watts, for a steadier
+	// display; an Alpha of 0 turns smoothing off
+	Alpha      float64
+	SmoothCPU  float64
+	SmoothGPU  float64
+	SmoothANE  float64
+	SmoothChip float64
+
+	// CPU power spikes are samples more than SpikeZ standard deviations
+	// above the window's mean; 0 turns detection off. Spike says whether
+	// the latest sample was one, and the rest describe the last one seen.
+	SpikeZ     float64
+	Spike      bool
+	SpikeWatts float64
+	SpikeScore float64
+	SpikeAt    time.Time
+
+	// Samples further apart than MaxGap (0 for no limit) on the wall
+	// clock, or out of order, mean the machine slept or the clock was set;
+	// energy isn't integrated across them and the charge trend starts
+	// over. Gap is how far the latest sample jumped, or 0.
+	MaxGap time.Duration
+	Gap    time.Duration
+}
+
+// StatsSnapshot is a copy of Stats made under the read lock. It needs no
+// locking.
+type StatsSnapshot struct {
+	sessionStats
+}
+
+// Snapshot takes the read lock and copies out the statistics so far.
+func (st *Stats) Snapshot() StatsSnapshot {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	s := StatsSnapshot{st.sessionStats}
+	// The snapshot gets its own rings and trailing windows, which Record
+	// updates in place
+	s.CPU, s.GPU, s.Package = st.CPU.clone(), st.GPU.clone(), st.Package.clone()
+	s.level, s.levelTime = st.level.clone(), st.levelTime.clone()
+	s.Chip1m, s.Chip15m = st.Chip1m.clone(), st.Chip15m.clone()
+	return s
+}
+
+// A spike needs a few samples to measure against, and a floor under the
+// deviation so an idle, perfectly flat CPU doesn't flag every flicker.
+const (
+	minSpikeSamples = 5
+	minSpikeStd     = 0.1
+)
+
+// HistogramEdges are the upper bounds of the package power buckets, in
+// watts.
+var HistogramEdges = [...]float64{2, 5, 10, 20}
+
+// Init sizes the window to the given number of samples and sets the
+// smoothing factor, 0 < alpha <= 1, where smaller is smoother.
+func (st *Stats) Init(window int, alpha float64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.Alpha = alpha
+	st.CPU = NewRing(window)
+	st.GPU = NewRing(window)
+	st.Package = NewRing(window)
+	st.level, st.levelTime = NewRing(window), NewRing(window)
+	st.Chip1m, st.Chip15m = Trailing{Span: time.Minute}, Trailing{Span: 15 * time.Minute}
+}
+
+// Reset clears everything accumulated so far, keeping the window size and
+// smoothing factor.
+func (st *Stats) Reset() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	window := len(st.CPU.vals)
+	st.CPU, st.GPU, st.Package = NewRing(window), NewRing(window), NewRing(window)
+	st.level, st.levelTime = NewRing(window), NewRing(window)
+	st.PackageWh, st.DrainWh, st.last = 0, 0, time.Time{}
+	st.SmoothCPU, st.SmoothGPU, st.SmoothANE, st.SmoothChip = 0, 0, 0, 0
+	st.PeakChip, st.PeakAt = 0, time.Time{}
+	st.Start, st.StartBattery = time.Time{}, 0
+	st.TotalCPU, st.TotalGPU, st.TotalANE, st.TotalChip = Totals{}, Totals{}, Totals{}, Totals{}
+	st.Chip1m, st.Chip15m = Trailing{Span: time.Minute}, Trailing{Span: 15 * time.Minute}
+	st.Histogram = [len(HistogramEdges) + 1]int{}
+	st.Spike, st.SpikeWatts, st.SpikeScore, st.SpikeAt = false, 0, 0, time.Time{}
+}
+
+func (st *Stats) Record(s Sample) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.Gap = 0
+	if !st.last.IsZero() {
+		// Round(0) drops the monotonic reading, which stops during sleep
+		gap := s.Time.Round(0).Sub(st.last.Round(0))
+		if gap < 0 || (st.MaxGap > 0 && gap > st.MaxGap) {
+			st.Gap = gap
+			window := len(st.level.vals)
+			st.level, st.levelTime = NewRing(window), NewRing(window)
+		}
+	}
+
+	// Measure against the window before this sample joins it
+	st.Spike = false
+	if st.SpikeZ > 0 && st.CPU.Len() >= minSpikeSamples {
+		mean, std := st.CPU.MeanStd()
+		if z := (s.CPUWatts - mean) / max(std, minSpikeStd); z > st.SpikeZ {
+			st.Spike = true
+			st.SpikeWatts, st.SpikeScore, st.SpikeAt = s.CPUWatts, z, s.Time
+		}
+	}
+
+	st.CPU.Push(s.CPUWatts)
+	st.GPU.Push(s.GPUWatts)
+	st.Package.Push(s.PackageWatts)
+	if s.BatteryLevel > 0 {
+		st.level.Push(s.BatteryLevel)
+		st.levelTime.Push(float64(s.Time.UnixNano()) / 1e9)
+	}
+	st.TotalCPU.Add(s.CPUWatts)
+	st.TotalGPU.Add(s.GPUWatts)
+	st.TotalANE.Add(s.ANEWatts)
+	st.TotalChip.Add(s.PackageWatts)
+	st.Chip1m.Add(s.Time, s.PackageWatts)
+	st.Chip15m.Add(s.Time, s.PackageWatts)
+	if st.Start.IsZero() {
+		st.Start = s.Time
+	}
+	if st.StartBattery == 0 {
+		st.StartBattery = s.BatteryPct
+	}
+	if st.PeakAt.IsZero() || s.PackageWatts > st.PeakChip {
+		st.PeakChip, st.PeakAt = s.PackageWatts, s.Time
+	}
+	bucket := len(HistogramEdges)
+	for i, edge := range HistogramEdges {
+		if s.PackageWatts < edge {
+			bucket = i
+			break
+		}
+	}
+	st.Histogram[bucket]++
+
+	// The first sample seeds the averages
+	ema := func(avg *float64, v float64) {
+		if st.last.IsZero() {
+			*avg = v
+			return
+		}
+		*avg += st.Alpha * (v - *avg)
+	}
+	if st.Alpha > 0 {
+		ema(&st.SmoothCPU, s.CPUWatts)
+		ema(&st.SmoothGPU, s.GPUWatts)
+		ema(&st.SmoothANE, s.ANEWatts)
+		ema(&st.SmoothChip, s.PackageWatts)
+	}
+
+	// Integrate over the time since the previous sample; the first sample
+	// has nothing to measure against
+	if !st.last.IsZero() && st.Gap == 0 {
+		hours := s.Time.Sub(st.last).Hours()
+		st.PackageWh += s.PackageWatts * hours
+		if s.BatteryWatts < 0 {
+			st.DrainWh += -s.BatteryWatts * hours
+		}
+	}
+	st.last = s.Time
+}
+
+// minTrendSpan is how much of the window the battery trend needs before
+// it means anything.
+const minTrendSpan = 30 * time.Second
+
+// LevelRate reports how fast the battery level is moving over the window,
+// in percent per hour, negative while draining. ok is false until the
+// window spans long enough to tell.
+func (st *Stats) LevelRate() (rate float64, ok bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.sessionStats.LevelRate()
+}
+
+// LevelRate is Stats.LevelRate; on a Stats, the caller holds the lock.
+func (st *sessionStats) LevelRate() (rate float64, ok bool) {
+	if st.level == nil || st.level.Len() < 2 {
+		return 0, false
+	}
+	levels, times := st.level.Values(), st.levelTime.Values()
+	span := times[len(times)-1] - times[0]
+	if span < minTrendSpan.Seconds() {
+		return 0, false
+	}
+	return (levels[len(levels)-1] - levels[0]) / span * 3600, true
+}
+
+// History keeps the most recent samples of each rail, in watts, for the
+// sparklines.
+type History struct {
+	CPU     *Ring
+	GPU     *Ring
+	ANE     *Ring
+	Package *Ring
+
+	// The same samples in full, for looking back at one
+	samples []Sample
+	next    int
+
+	mu sync.RWMutex
+}
+
+func (h *History) Init(size int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.CPU = NewRing(size)
+	h.GPU = NewRing(size)
+	h.ANE = NewRing(size)
+	h.Package = NewRing(size)
+	h.samples, h.next = make([]Sample, 0, size), 0
+}
+
+func (h *History) Record(s Sample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.CPU.Push(s.CPUWatts)
+	h.GPU.Push(s.GPUWatts)
+	h.ANE.Push(s.ANEWatts)
+	h.Package.Push(s.PackageWatts)
+	if len(h.samples) < cap(h.samples) {
+		h.samples = append(h.samples, s)
+	} else {
+		h.samples[h.next] = s
+	}
+	h.next = (h.next + 1) % cap(h.samples)
+}
+
+// Rails returns copies of each rail's recent values, oldest first.
+func (h *History) Rails() (cpu, gpu, ane, pkg []float64) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.CPU.Values(), h.GPU.Values(), h.ANE.Values(), h.Package.Values()
+}
+
+// Len is the number of samples kept.
+func (h *History) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.samples)
+}
+
+// Samples returns a copy of the samples kept, oldest first.
+func (h *History) Samples() []Sample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	n := len(h.samples)
+	out := make([]Sample, 0, n)
+	if n < cap(h.samples) {
+		return append(out, h.samples...)
+	}
+	out = append(out, h.samples[h.next:]...)
+	return append(out, h.samples[:h.next]...)
+}
+
+// At returns the sample back samples before the latest; ok is false past
+// the oldest one kept.
+func (h *History) At(back int) (s Sample, ok bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	n := len(h.samples)
+	if back < 0 || back >= n {
+		return s, false
+	}
+	return h.samples[((h.next-1-back)%n+n)%n], true
+}
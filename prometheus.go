@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// servePrometheus listens on addr and serves the current readings in the
+// Prometheus text exposition format at /metrics. Listening happens up front
+// so a bad address is reported at startup; requests are served in the
+// background.
+func servePrometheus(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+	go http.Serve(ln, mux)
+	return nil
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	s := data.Sample()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	gauge(w, "powermon_cpu_watts", "CPU power draw in watts.", s.CPUWatts)
+	gauge(w, "powermon_gpu_watts", "GPU power draw in watts.", s.GPUWatts)
+	gauge(w, "powermon_ane_watts", "Neural Engine power draw in watts.", s.ANEWatts)
+	gauge(w, "powermon_package_watts", "Combined CPU, GPU and ANE power draw in watts.", s.PackageWatts)
+	gauge(w, "powermon_battery_percent", "Battery charge in percent.", float64(s.BatteryPct))
+	gauge(w, "powermon_battery_watts", "Battery power in watts, negative while draining.", s.BatteryWatts)
+	gauge(w, "powermon_battery_temp_celsius", "Battery temperature in degrees Celsius.", s.TempC)
+	gauge(w, "powermon_charger_watts", "Power delivered by the charger in watts.", float64(s.ChargerWatts))
+	gauge(w, "powermon_on_ac", "1 when external power is connected.", boolGauge(s.OnAC))
+	gauge(w, "powermon_charging", "1 while the battery is charging.", boolGauge(s.IsCharging))
+}
+
+func gauge(w io.Writer, name, help string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, v)
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
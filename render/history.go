@@ -0,0 +1,72 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"powermon/power"
+)
+
+// HistoryHint is shown at the foot of the history view.
+const HistoryHint = "left/right scroll  h live  q quit"
+
+// RenderHistory builds one frame of the history view: chip power over the
+// recorded samples with a marker on the one back samples before the
+// latest, and that sample's readings in full. The chart scrolls once the
+// marker reaches its left edge.
+func RenderHistory(history *power.History, back int) string {
+	var frame strings.Builder
+	fmt.Fprint(&frame, "\033[H") // cursor home
+
+	_, _, _, chip := history.Rails()
+	s, ok := history.At(back)
+
+	const title = "HISTORY"
+	rule(&frame, "╔", "╗")
+	fmt.Fprintln(&frame, Line(strings.Repeat(" ", max((boxWidth-len(title))/2-1, 0))+title))
+	rule(&frame, "╠", "╣")
+	if !ok {
+		fmt.Fprintln(&frame, Line("  "+Dim+"no samples yet"+Reset))
+	} else {
+		width := boxWidth - 11
+		at := len(chip) - 1 - back
+		start := max(min(len(chip)-width, at), 0)
+		end := min(start+width, len(chip))
+		fmt.Fprintln(&frame, Line("  Chip  "+sparkline(chip[start:end], width, Magenta)))
+		fmt.Fprintln(&frame, Line("        "+strings.Repeat(" ", at-start)+Yellow+upArrow+Reset))
+
+		ago := "latest"
+		if back > 0 {
+			ago = uptime(time.Since(s.Time)) + " ago"
+		}
+		fmt.Fprintln(&frame, Line(fmt.Sprintf("  %s (%s) "+divider+" sample %d of %d", s.Time.Format("15:04:05"), ago, at+1, len(chip))))
+		rule(&frame, "╠", "╣")
+		fmt.Fprintln(&frame, Line(fmt.Sprintf("  CPU %s  GPU %s  ANE %s  Chip %s W",
+			fixed(s.CPUWatts, 2, 2), fixed(s.GPUWatts, 2, 2), fixed(s.ANEWatts, 2, 2), fixed(s.PackageWatts, 2, 2))))
+		if s.PCoreWatts > 0 || s.ECoreWatts > 0 {
+			fmt.Fprintln(&frame, Line(fmt.Sprintf("    P %s  E %s W", fixed(s.PCoreWatts, 2, 2), fixed(s.ECoreWatts, 2, 2))))
+		}
+		state := Red + "on battery" + Reset
+		switch {
+		case s.IsCharging:
+			state = Green + "charging" + Reset
+		case s.OnAC:
+			state = Blue + "on AC" + Reset
+		}
+		fmt.Fprintln(&frame, Line(fmt.Sprintf("  Battery %d%% "+divider+" %s W "+divider+" %s", s.BatteryPct, delta(s.BatteryWatts), state)))
+		if s.OnAC {
+			fmt.Fprintln(&frame, Line(fmt.Sprintf("  Charger %d W", s.ChargerWatts)))
+		}
+		thermal := ""
+		if s.ThermalState != "" {
+			thermal = "   thermal " + thermalColor(s.ThermalState) + s.ThermalState + Reset
+		}
+		fmt.Fprintln(&frame, Line("  Temp "+temperature(s.TempC)+thermal))
+	}
+	rule(&frame, "╠", "╣")
+	fmt.Fprintln(&frame, Line(Dim+HistoryHint+Reset))
+	rule(&frame, "╚", "╝")
+	fmt.Fprintln(&frame)
+	return frame.String()
+}
@@ -0,0 +1,31 @@
+package render
+
+import (
+	"fmt"
+
+	"powermon/power"
+)
+
+// Meter draws --meter's battery gauge on one line of cols columns: the
+// level bar, the percentage, and an arrow for which way the charge is
+// heading. The bar shrinks with the terminal, and gives way to just the
+// percentage when there's no room for it.
+func Meter(s power.Sample, cols int) string {
+	arrow := " " + Red + downArrow + Reset
+	switch {
+	case s.IsCharging:
+		arrow = " " + Green + upArrow + Reset
+	case s.OnAC:
+		arrow = ""
+	}
+	text := fmt.Sprintf("%d%%", s.BatteryPct) + arrow
+	if cols <= 0 {
+		cols = defaultWidth
+	}
+	// Leave the last column free so the line never wraps
+	bar := cols - VisibleLen(text) - 4
+	if bar < 4 {
+		return text
+	}
+	return "[" + levelBar(s.BatteryLevel, bar, Yellow) + "] " + text
+}
@@ -0,0 +1,69 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+
+	"powermon/power"
+)
+
+// DefaultFormat is the --oneline template used when none is given.
+const DefaultFormat = "CPU {cpu}W GPU {gpu}W BAT {bat}% {batw}W"
+
+// Oneline fills in the --format placeholders from a sample:
+//
+//	{cpu} {gpu} {ane} {chip}  silicon rails, watts
+//	{bat}                     battery percent
+//	{batw}                    battery watts, negative while draining
+//	{charger}                 charger watts
+//	{temp}                    battery temperature, °C (°F with Fahrenheit)
+//	{state}                   charging, full, or battery
+func Oneline(format string, s power.Sample) string {
+	batteryColor := Red
+	state := "battery"
+	switch {
+	case s.IsCharging:
+		batteryColor, state = Green, "charging"
+	case s.OnAC:
+		batteryColor, state = Blue, "full"
+	}
+
+	temp := s.TempC
+	if Fahrenheit {
+		temp = power.Fahrenheit(temp)
+	}
+
+	return strings.NewReplacer(
+		"{cpu}", fmt.Sprintf("%.1f", s.CPUWatts),
+		"{gpu}", fmt.Sprintf("%.1f", s.GPUWatts),
+		"{ane}", fmt.Sprintf("%.1f", s.ANEWatts),
+		"{chip}", fmt.Sprintf("%.1f", s.PackageWatts),
+		"{bat}", fmt.Sprintf("%d", s.BatteryPct),
+		"{batw}", batteryColor+fmt.Sprintf("%+.1f", s.BatteryWatts)+Reset,
+		"{charger}", fmt.Sprintf("%d", s.ChargerWatts),
+		"{temp}", fmt.Sprintf("%.1f", temp),
+		"{state}", state,
+	).Replace(format)
+}
+
+// Sentence describes a sample in plain words, for --accessible: no colors,
+// symbols or abbreviations a screen reader would stumble over.
+func Sentence(s power.Sample) string {
+	parts := []string{
+		fmt.Sprintf("CPU %.1f watts", s.CPUWatts),
+		fmt.Sprintf("GPU %.1f watts", s.GPUWatts),
+	}
+	if s.ANEWatts >= 0.05 {
+		parts = append(parts, fmt.Sprintf("Neural Engine %.1f watts", s.ANEWatts))
+	}
+	battery := fmt.Sprintf("battery %d percent", s.BatteryPct)
+	switch {
+	case s.IsCharging:
+		battery += fmt.Sprintf(" and charging at %.1f watts", s.BatteryWatts)
+	case s.OnAC:
+		battery += " and on AC power"
+	default:
+		battery += fmt.Sprintf(" and draining at %.1f watts", max(-s.BatteryWatts, 0))
+	}
+	return strings.Join(append(parts, battery), ", ") + "."
+}
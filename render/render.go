@@ -0,0 +1,1008 @@
+// Package render draws powermon's live display and one-line status.
+package render
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"powermon/power"
+)
+
+// ANSI colors, set by SetTheme and blanked by DisableColor
+var (
+	Reset   = "\033[0m"
+	Red     = "\033[31m"
+	Green   = "\033[32m"
+	Yellow  = "\033[33m"
+	Blue    = "\033[34m"
+	Magenta = "\033[35m"
+	Cyan    = "\033[36m"
+	White   = "\033[37m"
+	Dim     = "\033[2m"
+)
+
+// markStale dims a value that's stopped updating and tags it with "?".
+func markStale(stale bool, s string) string {
+	if !stale {
+		return s
+	}
+	return Dim + s + "?" + Reset
+}
+
+func DisableColor() {
+	Reset, Red, Green, Yellow, Blue, Magenta, Cyan, White, Dim = "", "", "", "", "", "", "", "", ""
+}
+
+func ColorBar(pct int, width int, color string) string {
+	return levelBar(float64(pct), width, color)
+}
+
+// levelBar is ColorBar for a fractional percentage.
+func levelBar(pct float64, width int, color string) string {
+	filled, empty := barCells(pct, width)
+	return color + strings.Repeat(barFull, filled) + Reset + Dim + strings.Repeat(barEmpty, empty) + Reset
+}
+
+// barCells splits a bar width cells wide into filled and empty at pct.
+func barCells(pct float64, width int) (filled, empty int) {
+	pct = min(max(pct, 0), 100)
+	filled = int(pct * float64(width) / 100)
+	return filled, max(width-filled, 0)
+}
+
+// repaints counts frames drawn, to move the charging pulse along.
+var repaints int
+
+// pulseBar is levelBar with a lighter cell sweeping rightward through the
+// fill, a step each repaint, so a charging battery shows it's gaining
+// before the percentage ticks up. A sweep takes about eight repaints.
+func pulseBar(pct float64, width int, color string) string {
+	filled, empty := barCells(pct, width)
+	if filled == 0 || Plain {
+		return levelBar(pct, width, color)
+	}
+	at := repaints * max(filled/8, 1) % filled
+	return color + strings.Repeat(barFull, at) + pulseFill + strings.Repeat(barFull, filled-at-1) + Reset +
+		Dim + strings.Repeat(barEmpty, empty) + Reset
+}
+
+// spikeHold is how long the CPU row stays highlighted after a spike.
+const spikeHold = 3 * time.Second
+
+// Precision, when not negative, overrides the number of decimals on watt
+// and volt readings.
+var Precision = -1
+
+// fixed formats v with def decimals, or Precision's, padded to room for
+// digits ahead of the point so columns stay lined up.
+func fixed(v float64, def, digits int) string {
+	p := def
+	if Precision >= 0 {
+		p = Precision
+	}
+	width := digits
+	if p > 0 {
+		width += p + 1
+	}
+	return fmt.Sprintf("%*.*f", width, p, v)
+}
+
+// Baseline, when set, adds a row with how far the rails are above it.
+var Baseline *power.Baseline
+
+// delta formats a signed difference in watts.
+func delta(v float64) string {
+	if v >= 0 {
+		return "+" + fixed(v, 1, 0)
+	}
+	return fixed(v, 1, 0)
+}
+
+// Started is when monitoring began, for the uptime in the footer.
+var Started time.Time
+
+// uptime formats d to the second under an hour, to the minute after.
+func uptime(d time.Duration) string {
+	if d < time.Hour {
+		return d.Truncate(time.Second).String()
+	}
+	return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// Panels are the sections of the live display, top to bottom.
+var Panels = []string{"silicon", "process", "histogram", "io", "fans", "charger", "battery", "health", "raw", "footer"}
+
+// Fields picks which Panels to draw; nil draws them all.
+var Fields map[string]bool
+
+func show(panel string) bool {
+	return Fields == nil || Fields[panel]
+}
+
+// SetFields limits the display to a comma-separated list of Panels.
+func SetFields(list string) error {
+	fields := map[string]bool{}
+	for _, f := range strings.Split(list, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" {
+			continue
+		}
+		if !slices.Contains(Panels, f) {
+			return fmt.Errorf("unknown panel %q (have %s)", f, strings.Join(Panels, ", "))
+		}
+		fields[f] = true
+	}
+	if len(fields) == 0 {
+		return errors.New("no panels listed")
+	}
+	Fields = fields
+	return nil
+}
+
+// Hint is shown under the clock, e.g. the keyboard controls.
+var Hint string
+
+// Alert is shown under the title, e.g. an output that has failed.
+var Alert string
+
+// Plain prints each frame after the last, headed by a timestamp, instead
+// of repainting the screen.
+var Plain bool
+
+// Borderless leaves out the box around the panels; Compact leaves out the
+// separators between them.
+var Borderless, Compact bool
+
+// ShowHistogram adds a panel with the session's chip power distribution.
+var ShowHistogram bool
+
+// ShowIO adds a panel with network and disk throughput.
+var ShowIO bool
+
+// ShowRaw adds a panel with ioreg's readings as read, before any unit
+// conversion, and which of its fields matched on the last poll.
+var ShowRaw bool
+
+// Fahrenheit shows temperatures in °F instead of °C.
+var Fahrenheit bool
+
+// Temperature formats c in the chosen unit.
+func Temperature(c float64) string {
+	if Fahrenheit {
+		return fmt.Sprintf("%.1f°F", power.Fahrenheit(c))
+	}
+	return fmt.Sprintf("%.1f°C", c)
+}
+
+// Battery temperature thresholds in °C, past which it's shown in yellow
+// and red; zero turns one off.
+var (
+	TempWarn = 40.0
+	TempCrit = 45.0
+)
+
+// temperature is Temperature colored against TempWarn and TempCrit, with
+// the threshold it has passed.
+func temperature(c float64) string {
+	limit, color := 0.0, ""
+	switch {
+	case TempCrit > 0 && c >= TempCrit:
+		limit, color = TempCrit, Red
+	case TempWarn > 0 && c >= TempWarn:
+		limit, color = TempWarn, Yellow
+	default:
+		return Temperature(c)
+	}
+	if Fahrenheit {
+		return fmt.Sprintf("%s%s (≥%g°F)%s", color, Temperature(c), math.Round(power.Fahrenheit(limit)), Reset)
+	}
+	return fmt.Sprintf("%s%s (≥%g°C)%s", color, Temperature(c), limit, Reset)
+}
+
+// Bar color thresholds for the silicon rails, in watts.
+var (
+	WarnWatts = 10.0
+	CritWatts = 20.0
+)
+
+// Full-scale readings for the rail bars, in watts; the P and E rows share
+// the CPU's. With AutoScale a bar stretches to the session's peak once
+// that passes its full scale.
+var (
+	CPUMax    = 10.0
+	GPUMax    = 10.0
+	ANEMax    = 10.0
+	AutoScale bool
+)
+
+// Milliwatts shows the silicon rails in whole mW rather than W, for
+// tuning idle power, where tens of mW matter.
+var Milliwatts bool
+
+// railWatts formats a silicon rail's reading, w watts, with its unit.
+func railWatts(w float64) string {
+	if Milliwatts {
+		return fmt.Sprintf("%5.0f mW", w*1000)
+	}
+	return fixed(w, 2, 2) + " W"
+}
+
+// railPct is how far along a rail's bar w watts reaches.
+func railPct(w, full float64) int {
+	return int(w * (100 / full))
+}
+
+// powerColor picks the bar color for a rail drawing w watts.
+func powerColor(w float64) string {
+	switch {
+	case w > CritWatts:
+		return Red
+	case w > WarnWatts:
+		return Yellow
+	default:
+		return Magenta
+	}
+}
+
+func SplitBar(sysPct, batPct, width int) string {
+	if sysPct < 0 {
+		sysPct = 0
+	}
+	if batPct < 0 {
+		batPct = 0
+	}
+	sysBars := sysPct * width / 100
+	batBars := width - sysBars
+	if sysBars < 0 {
+		sysBars = 0
+	}
+	if batBars < 0 {
+		batBars = 0
+	}
+	return Cyan + strings.Repeat(barFull, sysBars) + Reset + Yellow + strings.Repeat(splitFill, batBars) + Reset
+}
+
+// ShareBar splits width columns between the CPU, GPU and ANE in proportion
+// to their power.
+func ShareBar(cpu, gpu, ane float64, width int) string {
+	total := cpu + gpu + ane
+	if total <= 0 {
+		return strings.Repeat(barEmpty, width)
+	}
+	cpuBars := int(math.Round(cpu / total * float64(width)))
+	gpuBars := min(int(math.Round(gpu/total*float64(width))), width-cpuBars)
+	aneBars := max(width-cpuBars-gpuBars, 0)
+	return Magenta + strings.Repeat(barFull, cpuBars) + Reset +
+		Cyan + strings.Repeat(splitFill, gpuBars) + Reset +
+		Green + strings.Repeat(shareFill, aneBars) + Reset
+}
+
+// Glyphs for bars, sparklines and the box, swapped out by UseASCII
+var (
+	ascii      bool
+	barFull    = "█"
+	barEmpty   = "░"
+	splitFill  = "█" // battery side of the split bar; color tells it apart
+	shareFill  = "█" // ANE's part of the share bar, likewise
+	pulseFill  = "▓" // the charging pulse in the battery bar
+	sparkRunes = []rune("▁▂▃▄▅▆▇█")
+	horizontal = "═"
+	vertical   = "║"
+	divider    = "│" // between values on one row
+	warnSign   = "⚠"
+	upArrow    = "▲"
+	downArrow  = "▼"
+)
+
+// UseASCII draws with plain ASCII instead of block and box-drawing
+// characters, for terminals and fonts that mangle them.
+func UseASCII() {
+	ascii = true
+	barFull, barEmpty, splitFill, shareFill, pulseFill = "#", "-", "=", "+", ">"
+	sparkRunes = []rune("_.-=+*#@")
+	horizontal, vertical, divider = "-", "|", "|"
+	warnSign = "!"
+	upArrow, downArrow = "^", "v"
+}
+
+// sparkline draws vals, oldest first, as width block characters scaled to
+// the window's range. Longer histories are averaged down to fit.
+func sparkline(vals []float64, width int, color string) string {
+	if len(vals) == 0 {
+		return strings.Repeat(" ", width)
+	}
+	if len(vals) > width {
+		buckets := make([]float64, width)
+		for i := range buckets {
+			lo, hi := i*len(vals)/width, (i+1)*len(vals)/width
+			var sum float64
+			for _, v := range vals[lo:hi] {
+				sum += v
+			}
+			buckets[i] = sum / float64(hi-lo)
+		}
+		vals = buckets
+	}
+
+	min, max := vals[0], vals[0]
+	for _, v := range vals {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+
+	var b strings.Builder
+	for _, v := range vals {
+		i := 0
+		if max > min {
+			i = int((v - min) / (max - min) * float64(len(sparkRunes)-1))
+		}
+		b.WriteRune(sparkRunes[i])
+	}
+	return color + b.String() + Reset + strings.Repeat(" ", width-len(vals))
+}
+
+var ansiRe = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+func VisibleLen(s string) int {
+	return len([]rune(ansiRe.ReplaceAllString(s, "")))
+}
+
+// Width of the box interior, between "║ " and " ║". The layout was designed
+// around 52; SetWidth adapts it to the terminal.
+const (
+	defaultWidth = 52
+	minWidth     = 40
+)
+
+var boxWidth = defaultWidth
+
+// SetWidth fits the box to a terminal cols wide. Zero (not a terminal)
+// keeps the default width.
+func SetWidth(cols int) {
+	if cols <= 0 {
+		boxWidth = defaultWidth
+		return
+	}
+	boxWidth = max(cols-4, minWidth)
+}
+
+// border draws a horizontal box edge between the given corner pieces.
+func border(left, right string) string {
+	if ascii {
+		left, right = "+", "+"
+	}
+	return left + strings.Repeat(horizontal, boxWidth+2) + right
+}
+
+// rule writes a box edge, or a separator between panels, as the layout
+// calls for: Borderless drops the edges and leaves a blank row between
+// panels, and Compact drops the separators altogether.
+func rule(w io.Writer, left, right string) {
+	separator := left == "╠"
+	switch {
+	case separator && Compact:
+	case separator && Borderless:
+		fmt.Fprintln(w, Line(""))
+	case !Borderless:
+		fmt.Fprintln(w, border(left, right))
+	}
+}
+
+// truncate cuts s to n visible characters, keeping its escape codes.
+func truncate(s string, n int) string {
+	var b strings.Builder
+	visible := 0
+	for len(s) > 0 && visible < n {
+		if loc := ansiRe.FindStringIndex(s); loc != nil && loc[0] == 0 {
+			b.WriteString(s[:loc[1]])
+			s = s[loc[1]:]
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s)
+		b.WriteRune(r)
+		s = s[size:]
+		visible++
+	}
+	return b.String() + Reset
+}
+
+// Line pads content into one row of the box, truncating it if it overflows.
+func Line(content string) string {
+	visible := VisibleLen(content)
+	if visible > boxWidth {
+		content = truncate(content, boxWidth)
+		visible = boxWidth
+	}
+	pad := boxWidth - visible
+	edge := vertical
+	if Borderless {
+		edge = " "
+	}
+	return edge + " " + content + strings.Repeat(" ", pad) + " " + edge
+}
+
+// wrapWords lays words out in rows that fit the box, the first headed by
+// prefix and the rest indented to match. No words, no rows.
+func wrapWords(prefix string, words []string) []string {
+	var rows []string
+	indent := strings.Repeat(" ", VisibleLen(prefix))
+	row := prefix
+	for i, w := range words {
+		if i > 0 && VisibleLen(row)+1+VisibleLen(w) > boxWidth {
+			rows = append(rows, row)
+			row = indent + w
+			continue
+		}
+		if i > 0 {
+			row += " "
+		}
+		row += w
+	}
+	if len(words) > 0 {
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// rate formats a throughput in bytes/s with a binary unit prefix.
+func rate(bps float64) string {
+	units := []string{"B/s", "KB/s", "MB/s", "GB/s"}
+	i := 0
+	for bps >= 1024 && i < len(units)-1 {
+		bps /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", bps, units[i])
+}
+
+// thermalColor escalates with the thermal pressure level.
+func thermalColor(state string) string {
+	switch state {
+	case "Nominal":
+		return Green
+	case "Fair", "Moderate":
+		return Yellow
+	default:
+		return Red
+	}
+}
+
+// systemWatts estimates what the whole machine is drawing: the battery
+// drain when unplugged, otherwise whatever the charger supplies beyond what
+// goes into the battery.
+func systemWatts(d *power.PowerDataSnapshot) float64 {
+	batteryW := d.BatteryWatts()
+	if onAC, _ := d.Steady(); !onAC {
+		return -batteryW
+	}
+	return float64(d.ChargerWatts) - batteryW
+}
+
+// SmoothSplit is the time constant of a moving average the power split
+// shows in place of the charger and battery watts, which ioreg only
+// refreshes every 30s or so; zero shows them as read. Everything else,
+// logs included, gets them as read.
+var SmoothSplit time.Duration
+
+// split is the power split's moving average, restarted on each plug-in.
+var split struct {
+	charger, battery float64
+	at               time.Time
+}
+
+// smoothSplit moves the power split's averages toward chargerW and
+// batteryW as far as the time since the last frame allows, and returns
+// them. With SmoothSplit off it returns its arguments.
+func smoothSplit(chargerW, batteryW float64) (float64, float64) {
+	if SmoothSplit <= 0 {
+		return chargerW, batteryW
+	}
+	now := time.Now()
+	if split.at.IsZero() {
+		split.charger, split.battery = chargerW, batteryW
+	} else {
+		k := 1 - math.Exp(-float64(now.Sub(split.at))/float64(SmoothSplit))
+		split.charger += (chargerW - split.charger) * k
+		split.battery += (batteryW - split.battery) * k
+	}
+	split.at = now
+	return split.charger, split.battery
+}
+
+// vaMismatch reports whether the charger's watts are further from volts
+// times amps than an adapter's rounding explains: 10%, or 2 W for small
+// chargers. Missing readings aren't a mismatch.
+func vaMismatch(watts int, volts, amps float64) bool {
+	if watts == 0 || volts == 0 || amps == 0 {
+		return false
+	}
+	return math.Abs(volts*amps-float64(watts)) > max(0.1*float64(watts), 2)
+}
+
+// machineRow headlines the total draw so it reads the same on AC and battery.
+func machineRow(w float64) string {
+	return fmt.Sprintf("  Machine: "+White+"%s W"+Reset+" total", fixed(w, 1, 0))
+}
+
+// renderHistogram draws the share of samples that fell in each chip power
+// bucket, showing idle versus burst behavior over the session.
+func renderHistogram(w io.Writer, stats *power.StatsSnapshot) {
+	counts := stats.Histogram
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+
+	rule(w, "╠", "╣")
+	fmt.Fprintln(w, Line(Magenta + "DISTRIBUTION" + Reset + " (chip power, session)"))
+	barWidth := max(boxWidth-18, 4)
+	lo := 0.0
+	for i, n := range counts {
+		label := fmt.Sprintf("%g+ W", lo)
+		if i < len(power.HistogramEdges) {
+			label = fmt.Sprintf("%g-%g W", lo, power.HistogramEdges[i])
+			lo = power.HistogramEdges[i]
+		}
+		pct := 0
+		if total > 0 {
+			pct = n * 100 / total
+		}
+		fmt.Fprintln(w, Line(fmt.Sprintf("  %8s [%s] %3d%%", label, ColorBar(pct, barWidth, Magenta), pct)))
+	}
+}
+
+// batteryRows draws a battery's charge, readings, status and level bar.
+// The bar follows level, which may be finer than the pct shown; eta, if
+// known, follows the status. held marks a charge paused by optimized
+// charging, which would otherwise pass for full.
+func batteryRows(w io.Writer, pct int, level float64, volts, amps, temp string, charging, onAC, held bool, eta string, bar int) {
+	status := Red + "draining" + Reset
+	switch {
+	case charging:
+		status = Green + "charging" + Reset
+	case held:
+		status = Blue + fmt.Sprintf("held at %d%% (optimized charging)", power.ChargeLimit) + Reset
+	case onAC:
+		status = Blue + "full/maintaining" + Reset
+	}
+	if eta != "" {
+		status += " " + divider + " " + eta
+	}
+	fmt.Fprintln(w, Line(fmt.Sprintf("  %d%% "+divider+" %s "+divider+" %s "+divider+" %s", pct, volts, amps, temp)))
+	fmt.Fprintln(w, Line(fmt.Sprintf("  %s", status)))
+	drawBar := levelBar
+	if charging {
+		drawBar = pulseBar
+	}
+	fmt.Fprintln(w, Line(fmt.Sprintf("  [%s]", drawBar(level, bar, Yellow))))
+}
+
+// netRow says which way a battery's charge is moving, and how fast, on AC
+// or off. Under 0.1 W either way is sensor noise.
+func netRow(w float64) string {
+	switch {
+	case w >= 0.1:
+		return "  Net: " + Green + upArrow + " +" + fixed(w, 1, 0) + " W" + Reset + " gaining"
+	case w <= -0.1:
+		return "  Net: " + Red + downArrow + " " + fixed(w, 1, 0) + " W" + Reset + " losing"
+	}
+	return "  Net: " + Dim + "0 W steady" + Reset
+}
+
+// batteryETA estimates the time to empty or full from the charge trend.
+func batteryETA(level, rate float64, charging, onAC bool) string {
+	var hours float64
+	var what string
+	switch {
+	case charging && rate > 0:
+		hours, what = (100-level)/rate, "to full"
+	case !onAC && rate < 0:
+		hours, what = level/-rate, "to empty"
+	default:
+		return ""
+	}
+	if hours > 48 {
+		return ""
+	}
+	d := time.Duration(hours * float64(time.Hour)).Round(time.Minute)
+	if d < time.Hour {
+		return fmt.Sprintf("~%dm %s", int(d.Minutes()), what)
+	}
+	return fmt.Sprintf("~%dh%02dm %s", int(d.Hours()), int(d.Minutes())%60, what)
+}
+
+// chargeRate shows how fast the level is climbing, or "--" until the
+// statistics window is long enough to tell.
+func chargeRate(rate float64, ok bool) string {
+	if !ok {
+		return "  Rate: " + Dim + "--" + Reset
+	}
+	return fmt.Sprintf("  Rate: "+Green+"%+.0f%%/hr"+Reset, rate)
+}
+
+// trend compares the last minute's average power with the last quarter
+// hour's: a gap of over 10%, and half a watt, is the machine warming up
+// or cooling down.
+func trend(short, long float64) string {
+	switch d := short - long; {
+	case d > max(0.1*long, 0.5):
+		return " " + Red + upArrow + " rising" + Reset
+	case -d > max(0.1*long, 0.5):
+		return " " + Green + downArrow + " falling" + Reset
+	}
+	return ""
+}
+
+// Render builds one full frame of the live display from the current
+// readings, statistics and history, ready to print as is.
+func Render(pd *power.PowerData, st *power.Stats, history *power.History) string {
+	var frame strings.Builder
+	data, stats := pd.Snapshot(), st.Snapshot()
+	repaints++
+
+	if Plain {
+		fmt.Fprintln(&frame, time.Now().Format("2006-01-02 15:04:05"))
+	} else {
+		fmt.Fprint(&frame, "\033[H") // cursor home
+	}
+
+	cpuW, gpuW, aneW, siliconW := data.CPUWatts, data.GPUWatts, data.ANEWatts, data.PackageWatts
+
+	// With --smooth the numbers and bars show the moving average; the
+	// sparklines and statistics stay raw
+	if stats.Alpha > 0 && stats.CPU.Len() > 0 {
+		cpuW, gpuW, aneW, siliconW = stats.SmoothCPU, stats.SmoothGPU, stats.SmoothANE, stats.SmoothChip
+	}
+
+	chargerV, chargerA := data.ChargerVolts, data.ChargerAmps
+	batteryV, batteryW, tempC := data.BatteryVolts, data.BatteryWatts(), data.TempC
+
+	// Bars grow and shrink with the box, and the rail bars give way to
+	// wider numbers; at the default width and precision these are 20, 40
+	// and 44 columns
+	railNum := len(railWatts(0)) - len(" W")
+	railBar := max(boxWidth-27-railNum, 4)
+	splitWidth := boxWidth - 12
+	batteryBar := boxWidth - 8
+
+	const title = "LIVE POWER MONITOR  (Ctrl+C to stop)"
+	rule(&frame, "╔", "╗")
+	fmt.Fprintln(&frame, Line(strings.Repeat(" ", max((boxWidth-len(title))/2-1, 0)) + title))
+	if Alert != "" {
+		fmt.Fprintln(&frame, Line(Red+warnSign+" "+Alert+Reset))
+	}
+	if show("silicon") {
+		rule(&frame, "╠", "╣")
+		if data.NoSilicon {
+			fmt.Fprintln(&frame, Line(Magenta + "SILICON" + Reset))
+			fmt.Fprintln(&frame, Line("  " + Dim + "needs sudo (run: sudo powermon)" + Reset))
+		} else {
+			state := " (live)"
+			if data.Reconnecting {
+				state = " " + Yellow + "reconnecting..." + Reset
+			}
+			fmt.Fprintln(&frame, Line(Magenta + "SILICON" + Reset + state))
+			cpuH, gpuH, aneH, chipH := history.Rails()
+
+			cpuFull, gpuFull, aneFull := CPUMax, GPUMax, ANEMax
+			if AutoScale {
+				cpuFull = max(cpuFull, stats.TotalCPU.Max)
+				gpuFull = max(gpuFull, stats.TotalGPU.Max)
+				aneFull = max(aneFull, stats.TotalANE.Max)
+			}
+
+			// Sparklines sit under each bar, lined up with its inside
+			sparkIndent := strings.Repeat(" ", railNum+13)
+			// A recent spike highlights the row for a moment
+			cpuLabel, spike := "CPU:", ""
+			if !stats.SpikeAt.IsZero() && time.Since(stats.SpikeAt) < spikeHold {
+				cpuLabel, spike = Red+cpuLabel+Reset, " "+Red+warnSign+" spike"+Reset
+			}
+			fmt.Fprintln(&frame, Line(fmt.Sprintf("  %s  %s  [%s]%s", cpuLabel, railWatts(cpuW), ColorBar(railPct(cpuW, cpuFull), railBar, powerColor(cpuW)), spike)))
+			fmt.Fprintln(&frame, Line(sparkIndent + sparkline(cpuH, railBar, Magenta)))
+			if data.PCorePower > 0 || data.ECorePower > 0 {
+				pW, eW := data.PCorePower/1000, data.ECorePower/1000
+				fmt.Fprintln(&frame, Line(fmt.Sprintf("    P:  %s  [%s]", railWatts(pW), ColorBar(railPct(pW, cpuFull), railBar, powerColor(pW)))))
+				fmt.Fprintln(&frame, Line(fmt.Sprintf("    E:  %s  [%s]", railWatts(eW), ColorBar(railPct(eW, cpuFull), railBar, powerColor(eW)))))
+			}
+			// Residency tells idle apart from busy-but-efficient
+			gpuActive := ""
+			if data.HasGPUActive {
+				gpuActive = fmt.Sprintf(" (%.0f%% active)", data.GPUActive)
+			}
+			fmt.Fprintln(&frame, Line(fmt.Sprintf("  GPU:  %s  [%s]%s", railWatts(gpuW), ColorBar(railPct(gpuW, gpuFull), railBar, powerColor(gpuW)), gpuActive)))
+			fmt.Fprintln(&frame, Line(sparkIndent + sparkline(gpuH, railBar, Magenta)))
+			if !data.Intel {
+				fmt.Fprintln(&frame, Line(fmt.Sprintf("  ANE:  %s  [%s]", railWatts(aneW), ColorBar(railPct(aneW, aneFull), railBar, powerColor(aneW)))))
+				fmt.Fprintln(&frame, Line(sparkIndent + sparkline(aneH, railBar, Magenta)))
+			}
+			// Raw temperature doesn't say whether macOS is throttling; the
+			// pressure level does
+			thermal := ""
+			if data.ThermalState != "" {
+				thermal = "   thermal " + thermalColor(data.ThermalState) + data.ThermalState + Reset
+			}
+			fmt.Fprintln(&frame, Line(fmt.Sprintf("  Chip: %s", railWatts(siliconW)) + thermal))
+			if Baseline != nil {
+				fmt.Fprintln(&frame, Line("  " + Dim + "vs base" + Reset + fmt.Sprintf("  CPU %s  GPU %s  Chip %s W",
+					delta(cpuW-Baseline.CPU), delta(gpuW-Baseline.GPU), delta(siliconW-Baseline.Package))))
+			}
+			fmt.Fprintln(&frame, Line(sparkIndent + sparkline(chipH, railBar, Magenta)))
+
+			// How the chip's power divides, which tells a CPU-bound
+			// workload from a GPU-bound one at a glance
+			if total := cpuW + gpuW + aneW; !data.Intel && total > 0 {
+				fmt.Fprintln(&frame, Line(fmt.Sprintf("  %-*s[%s]", railNum+10, "Share:", ShareBar(cpuW, gpuW, aneW, railBar))))
+				fmt.Fprintln(&frame, Line(sparkIndent+fmt.Sprintf(Magenta+"CPU %.0f%%"+Reset+"  "+Cyan+"GPU %.0f%%"+Reset+"  "+Green+"ANE %.0f%%"+Reset,
+					cpuW/total*100, gpuW/total*100, aneW/total*100)))
+			}
+
+			cpuS, ok := stats.CPU.Summary()
+			gpuS, _ := stats.GPU.Summary()
+			chipS, _ := stats.Package.Summary()
+			if ok {
+				fmt.Fprintln(&frame, Line(fmt.Sprintf("  "+Dim+"min"+Reset+"  CPU %s  GPU %s  Chip %s W", fixed(cpuS.Min, 2, 2), fixed(gpuS.Min, 2, 2), fixed(chipS.Min, 2, 2))))
+				fmt.Fprintln(&frame, Line(fmt.Sprintf("  "+Dim+"avg"+Reset+"  CPU %s  GPU %s  Chip %s W", fixed(cpuS.Avg, 2, 2), fixed(gpuS.Avg, 2, 2), fixed(chipS.Avg, 2, 2))))
+				fmt.Fprintln(&frame, Line(fmt.Sprintf("  "+Dim+"max"+Reset+"  CPU %s  GPU %s  Chip %s W", fixed(cpuS.Max, 2, 2), fixed(gpuS.Max, 2, 2), fixed(chipS.Max, 2, 2))))
+			}
+		}
+	}
+
+	// The watched process's panel ends when the process does
+	if data.WatchPID > 0 && !data.ProcessGone && show("process") && !data.NoSilicon {
+		rule(&frame, "╠", "╣")
+		fmt.Fprintln(&frame, Line(fmt.Sprintf(Magenta+"PROCESS"+Reset+" %s (%d)", data.ProcessName, data.WatchPID)))
+		fmt.Fprintln(&frame, Line(fmt.Sprintf("  ~%s W  "+Dim+"(%.0f%% of CPU time, estimated)"+Reset, fixed(data.ProcessWatts(), 1, 2), data.ProcessShare*100)))
+	}
+
+	if ShowHistogram && show("histogram") && !data.NoSilicon {
+		renderHistogram(&frame, &stats)
+	}
+
+	if ShowIO && show("io") && !data.NoSilicon {
+		rule(&frame, "╠", "╣")
+		fmt.Fprintln(&frame, Line(Cyan + "I/O" + Reset))
+		fmt.Fprintln(&frame, Line(fmt.Sprintf("  Net:   in   %10s   out   %10s", rate(data.NetIn), rate(data.NetOut))))
+		fmt.Fprintln(&frame, Line(fmt.Sprintf("  Disk:  read %10s   write %10s", rate(data.DiskRead), rate(data.DiskWrite))))
+	}
+
+	// Fanless machines (and Apple Silicon, which has no smc sampler) never
+	// report a nonzero speed, so the panel stays hidden there
+	spinning := false
+	for _, rpm := range data.FanRPM {
+		spinning = spinning || rpm > 0
+	}
+	if spinning && show("fans") {
+		rule(&frame, "╠", "╣")
+		fmt.Fprintln(&frame, Line(Blue + "FANS" + Reset))
+		for i, rpm := range data.FanRPM {
+			label := "Fan:"
+			if len(data.FanRPM) > 1 {
+				label = fmt.Sprintf("Fan %d:", i+1)
+			}
+			fmt.Fprintln(&frame, Line(fmt.Sprintf("  %-7s %5.0f rpm", label, rpm)))
+		}
+	}
+
+	// A marginal charger can flap between reads; the layout waits for it
+	// to settle
+	onAC, charging := data.Steady()
+	if show("charger") {
+		rule(&frame, "╠", "╣")
+		if onAC {
+			systemW := systemWatts(&data)
+			fmt.Fprintln(&frame, Line(Green + "CHARGER" + Reset))
+			fmt.Fprintln(&frame, Line(machineRow(systemW)))
+			volts := markStale(data.Stale("adapterV"), fixed(chargerV, 1, 0)+"V")
+			amps := markStale(data.Stale("adapterA"), fmt.Sprintf("%.2fA", chargerA))
+			watts := Green + fmt.Sprintf("%dW", data.ChargerWatts) + Reset
+			if data.Stale("watts") {
+				watts = markStale(true, fmt.Sprintf("%dW", data.ChargerWatts))
+			}
+			// A stale or misread field shows up as watts that don't match
+			// volts times amps
+			if vaMismatch(data.ChargerWatts, chargerV, chargerA) {
+				watts += " " + Yellow + warnSign + fmt.Sprintf(" V×A %.0fW", chargerV*chargerA) + Reset
+			}
+			fmt.Fprintln(&frame, Line(fmt.Sprintf("  %s × %s = %s", volts, amps, watts)))
+			if data.AdapterName != "" || data.AdapterRatedWatts > 0 {
+				name := data.AdapterName
+				if name == "" {
+					name = "adapter"
+				}
+				if data.AdapterRatedWatts > 0 && !strings.HasPrefix(name, fmt.Sprintf("%dW", data.AdapterRatedWatts)) {
+					name = fmt.Sprintf("%dW %s", data.AdapterRatedWatts, name)
+				}
+				// A charger negotiating less than it's rated for is often the
+				// cable or port, worth calling out
+				negotiated := fmt.Sprintf("(negotiated %dW)", data.ChargerWatts)
+				if data.ChargerWatts < data.AdapterRatedWatts {
+					negotiated = Yellow + negotiated + Reset
+				}
+				fmt.Fprintln(&frame, Line(fmt.Sprintf("  %s %s", name, negotiated)))
+			}
+			// Charging can behave differently from one port to another
+			if data.AdapterPort != "" {
+				fmt.Fprintln(&frame, Line("  Port: "+data.AdapterPort))
+			}
+			if charging {
+				fmt.Fprintln(&frame, Line(chargeRate(stats.LevelRate())))
+			}
+			// Plugged in but still draining: the load outruns the charger. The
+			// margin keeps sensor noise on a full battery from tripping it.
+			if batteryW < -0.5 {
+				fmt.Fprintln(&frame, Line("  " + Red + warnSign + " charger undersized: drawing from battery" + Reset))
+			}
+			rule(&frame, "╠", "╣")
+			title := "POWER SPLIT (~30s refresh)"
+			if SmoothSplit > 0 {
+				title = fmt.Sprintf("POWER SPLIT (%v average)", SmoothSplit)
+			}
+			fmt.Fprintln(&frame, Line(title))
+			splitCharger, splitBattery := smoothSplit(float64(data.ChargerWatts), batteryW)
+			fmt.Fprintln(&frame, Line(fmt.Sprintf("  → " + Cyan + "System:  %s W" + Reset, fixed(splitCharger-splitBattery, 1, 3))))
+			fmt.Fprintln(&frame, Line(fmt.Sprintf("  → " + Yellow + "Battery: %s W" + Reset, fixed(splitBattery, 1, 3))))
+
+			// Where ioreg measures the load directly, check it and the battery
+			// against what the charger says it's delivering; the gap is
+			// conversion loss, rounding and unused headroom
+			if systemW < 0 {
+				fmt.Fprintln(&frame, Line("  " + Red + warnSign + " implausible readings: system below 0 W" + Reset))
+			} else if data.SystemLoad > 0 && data.ChargerWatts > 0 {
+				accounted := float64(data.SystemLoad)/1000 + batteryW
+				gap := (float64(data.ChargerWatts) - accounted) / float64(data.ChargerWatts) * 100
+				fmt.Fprintln(&frame, Line(fmt.Sprintf("  "+Dim+"accounted %s of %d W (%.0f%% unaccounted)"+Reset, fixed(accounted, 1, 0), data.ChargerWatts, gap)))
+			}
+
+			// Visual split bar, which divides by the smoothed watts
+			if splitCharger > 0 {
+				batteryPct := int((splitBattery / splitCharger) * 100)
+				if batteryPct < 0 {
+					batteryPct = 0
+				}
+				if batteryPct > 100 {
+					batteryPct = 100
+				}
+				systemPct := 100 - batteryPct
+				fmt.Fprintln(&frame, Line(fmt.Sprintf("  [%s]", SplitBar(systemPct, batteryPct, splitWidth))))
+				fmt.Fprintln(&frame, Line(fmt.Sprintf("   " + Cyan + "system %d%%" + Reset + "          " + Yellow + "battery %d%%" + Reset, systemPct, batteryPct)))
+			}
+		} else {
+			split.at = time.Time{}
+			fmt.Fprintln(&frame, Line(Red + "ON BATTERY" + Reset))
+			fmt.Fprintln(&frame, Line(machineRow(systemWatts(&data))))
+		}
+	}
+
+	if show("battery") {
+		rule(&frame, "╠", "╣")
+		title := Yellow + "BATTERY" + Reset
+		if data.LowPowerMode {
+			title += "  " + Green + "LOW POWER MODE" + Reset
+		}
+		fmt.Fprintln(&frame, Line(title))
+		level := data.BatteryLevel
+		if level == 0 {
+			level = float64(data.BatteryPct)
+		}
+		eta := ""
+		if rate, ok := stats.LevelRate(); ok {
+			eta = batteryETA(level, rate, charging, onAC)
+		}
+		batteryRows(&frame, data.BatteryPct, level,
+			markStale(data.Stale("batteryV"), fixed(batteryV, 2, 0)+"V"),
+			markStale(data.Stale("batteryA"), fmt.Sprintf("%dmA", data.BatteryAmps)),
+			markStale(data.Stale("temp"), temperature(tempC)),
+			charging, onAC, data.ChargeHeld(onAC, charging), eta, batteryBar)
+		fmt.Fprintln(&frame, Line(netRow(batteryW)))
+		// Watt-hours compare directly with the draw, where percent doesn't
+		if remaining, full := data.EnergyWh(); full > 0 {
+			fmt.Fprintln(&frame, Line(fmt.Sprintf("  Remaining: %s / %s Wh", fixed(remaining, 1, 0), fixed(full, 1, 0))))
+		}
+		// With a baseline taken in normal mode, show what Low Power Mode
+		// is saving
+		if data.LowPowerMode && Baseline != nil {
+			fmt.Fprintln(&frame, Line("  Low Power Mode: chip "+delta(siliconW-Baseline.Package)+" W vs baseline"))
+		}
+
+		// Any further sources, such as an external battery pack, get a panel
+		// each
+		for _, b := range data.Batteries[min(len(data.Batteries), 1):] {
+			rule(&frame, "╠", "╣")
+			fmt.Fprintln(&frame, Line(Yellow + "BATTERY" + Reset + " " + b.Name))
+			batteryRows(&frame, b.Percent, float64(b.Percent),
+				fixed(float64(b.Voltage)/1000, 2, 0)+"V",
+				fmt.Sprintf("%dmA", b.Amps),
+				temperature(float64(b.Temperature)/100),
+				b.IsCharging, b.OnAC, false, "", batteryBar)
+			fmt.Fprintln(&frame, Line(netRow(b.Watts())))
+		}
+	}
+
+	if show("health") && data.DesignCapacity > 0 && data.MaxCapacity > 0 {
+		health := data.MaxCapacity * 100 / data.DesignCapacity
+		color := Green
+		switch {
+		case health < 60:
+			color = Red
+		case health < 80:
+			color = Yellow
+		}
+		rule(&frame, "╠", "╣")
+		fmt.Fprintln(&frame, Line(Yellow + "BATTERY HEALTH" + Reset))
+		fmt.Fprintln(&frame, Line(fmt.Sprintf("  "+color+"%d%%"+Reset+" of design (%d/%d mAh) "+divider+" %d cycles",
+			health, data.MaxCapacity, data.DesignCapacity, data.CycleCount)))
+	}
+
+	if poll := data.Updated["ioreg"]; ShowRaw && show("raw") && !poll.IsZero() {
+		rule(&frame, "╠", "╣")
+		fmt.Fprintln(&frame, Line("RAW (ioreg)"))
+		raw := func(a string, av int, b string, bv int) string {
+			return fmt.Sprintf("  %-15s %-7d %-15s %d", a, av, b, bv)
+		}
+		fmt.Fprintln(&frame, Line(raw("ChargerWatts", data.ChargerWatts, "BatteryVoltage", data.BatteryVoltage)))
+		fmt.Fprintln(&frame, Line(raw("ChargerVoltage", data.ChargerVoltage, "BatteryAmps", data.BatteryAmps)))
+		fmt.Fprintln(&frame, Line(raw("ChargerCurrent", data.ChargerCurrent, "Temperature", data.Temperature)))
+		var matched, missed []string
+		for _, key := range power.IoregKeys() {
+			if data.Updated[key].Equal(poll) {
+				matched = append(matched, key)
+			} else {
+				missed = append(missed, Red+key+Reset)
+			}
+		}
+		for _, row := range wrapWords("  matched: ", matched) {
+			fmt.Fprintln(&frame, Line(row))
+		}
+		for _, row := range wrapWords("  missed:  ", missed) {
+			fmt.Fprintln(&frame, Line(row))
+		}
+	}
+
+	packageWh, drainWh := stats.PackageWh, stats.DrainWh
+	peakW, peakAt := stats.PeakChip, stats.PeakAt
+	spikeW, spikeZ, spikeAt := stats.SpikeWatts, stats.SpikeScore, stats.SpikeAt
+	avg1m, ok := stats.Chip1m.Avg()
+	avg15m, _ := stats.Chip15m.Avg()
+
+	if show("footer") {
+		rule(&frame, "╠", "╣")
+		fmt.Fprintln(&frame, Line(fmt.Sprintf("Energy: chip %.3f Wh "+divider+" battery drain %.3f Wh", packageWh, drainWh)))
+		if ok {
+			fmt.Fprintln(&frame, Line(fmt.Sprintf("Average: chip 1m %s W "+divider+" 15m %s W", fixed(avg1m, 1, 0), fixed(avg15m, 1, 0))+trend(avg1m, avg15m)))
+		}
+		if !peakAt.IsZero() {
+			fmt.Fprintln(&frame, Line(fmt.Sprintf("Peak: chip %s W at %s", fixed(peakW, 1, 0), peakAt.Format("15:04:05"))))
+		}
+		if !spikeAt.IsZero() {
+			fmt.Fprintln(&frame, Line(fmt.Sprintf("Last spike: CPU %s W (z %.1f) at %s", fixed(spikeW, 1, 0), spikeZ, spikeAt.Format("15:04:05"))))
+		}
+		clock := time.Now().Format("15:04:05")
+		if !Started.IsZero() {
+			noun := "samples"
+			if data.Samples == 1 {
+				noun = "sample"
+			}
+			clock += fmt.Sprintf("  up %s "+divider+" %d %s", uptime(time.Since(Started)), data.Samples, noun)
+		}
+		fmt.Fprintln(&frame, Line(clock))
+		if Hint != "" {
+			fmt.Fprintln(&frame, Line(Dim + Hint + Reset))
+		}
+	}
+
+	rule(&frame, "╚", "╝")
+	fmt.Fprintln(&frame)
+	return frame.String()
+}
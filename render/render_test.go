@@ -0,0 +1,77 @@
+package render
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"powermon/power"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden frames in testdata")
+
+// The footer's clock and uptime change from run to run, so the golden
+// frames leave it out.
+const goldenPanels = "silicon,charger,battery,health"
+
+func TestRenderGolden(t *testing.T) {
+	DisableColor()
+	if err := SetFields(goldenPanels); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { Fields = nil }()
+
+	tests := []struct {
+		name string
+		set  func(d *power.PowerData)
+	}{
+		{"charger", func(d *power.PowerData) {
+			d.OnAC = true
+			d.ChargerWatts, d.ChargerVoltage, d.ChargerCurrent = 60, 20000, 3000
+			d.AdapterName, d.AdapterRatedWatts, d.AdapterPort = "96W USB-C Power Adapter", 96, "USB-C 2"
+			d.BatteryAmps = 500
+		}},
+		{"battery", func(d *power.PowerData) {
+			d.BatteryAmps = -1200
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pd power.PowerData
+			pd.BatteryPct, pd.BatteryVoltage, pd.Temperature = 87, 12790, 3012
+			pd.DesignCapacity, pd.MaxCapacity, pd.CycleCount = 6075, 5890, 213
+			tt.set(&pd)
+
+			var stats power.Stats
+			var history power.History
+			stats.Init(10, 0)
+			history.Init(10)
+			start := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+			for i, cpu := range []float64{1800, 2600, 4200} {
+				pd.CPUPower, pd.GPUPower, pd.ANEPower = cpu, 800, 0
+				pd.PackagePower = cpu + 800
+				s := pd.Sample()
+				s.Time = start.Add(time.Duration(i) * time.Second)
+				stats.Record(s)
+				history.Record(s)
+			}
+
+			got := Render(&pd, &stats, &history)
+			golden := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("frame differs from %s (rerun with -update if that's intended)\n got:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
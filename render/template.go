@@ -0,0 +1,56 @@
+package render
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"text/template"
+)
+
+// TemplateFuncs are the helpers a --template can call on top of
+// text/template's own:
+//
+//	color NAME TEXT   TEXT in red, green, yellow, blue, magenta, cyan,
+//	                  white or dim; plain when colors are off
+//	bar PCT WIDTH     a bar WIDTH cells wide, PCT percent full
+//	round V PLACES    V rounded to PLACES decimals
+var TemplateFuncs = template.FuncMap{
+	"color": func(name string, text any) (string, error) {
+		code, ok := map[string]string{
+			"red": Red, "green": Green, "yellow": Yellow, "blue": Blue,
+			"magenta": Magenta, "cyan": Cyan, "white": White, "dim": Dim,
+		}[name]
+		if !ok {
+			return "", fmt.Errorf("no color %q", name)
+		}
+		return code + fmt.Sprint(text) + Reset, nil
+	},
+	"bar": func(pct any, width int) (string, error) {
+		f, err := toFloat(pct)
+		return levelBar(f, width, ""), err
+	},
+	"round": func(v any, places int) (float64, error) {
+		f, err := toFloat(v)
+		scale := math.Pow(10, float64(places))
+		return math.Round(f*scale) / scale, err
+	},
+}
+
+// toFloat takes any number, so templates can pass ints and floats alike.
+func toFloat(v any) (float64, error) {
+	r := reflect.ValueOf(v)
+	switch {
+	case r.CanFloat():
+		return r.Float(), nil
+	case r.CanInt():
+		return float64(r.Int()), nil
+	case r.CanUint():
+		return float64(r.Uint()), nil
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+// ParseTemplate parses a --template, with TemplateFuncs to hand.
+func ParseTemplate(text string) (*template.Template, error) {
+	return template.New("template").Funcs(TemplateFuncs).Parse(text)
+}
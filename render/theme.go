@@ -0,0 +1,66 @@
+package render
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Themes map each color role to its ANSI code. The roles are named after
+// the default dark palette, so "yellow" is whatever the theme uses for the
+// battery, "cyan" for the system's share of the charger, and so on.
+var Themes = map[string]map[string]string{
+	"dark": {
+		"red":     "\033[31m",
+		"green":   "\033[32m",
+		"yellow":  "\033[33m",
+		"blue":    "\033[34m",
+		"magenta": "\033[35m",
+		"cyan":    "\033[36m",
+		"white":   "\033[37m",
+		"dim":     "\033[2m",
+	},
+	// Deeper shades that hold up on a white background
+	"light": {
+		"red":     "\033[38;5;160m",
+		"green":   "\033[38;5;28m",
+		"yellow":  "\033[38;5;130m",
+		"blue":    "\033[38;5;19m",
+		"magenta": "\033[38;5;90m",
+		"cyan":    "\033[38;5;30m",
+		"white":   "\033[30m",
+		"dim":     "\033[38;5;245m",
+	},
+	// Solarized's accents, which read on its light and dark backgrounds
+	"solarized": {
+		"red":     "\033[38;5;160m",
+		"green":   "\033[38;5;64m",
+		"yellow":  "\033[38;5;136m",
+		"blue":    "\033[38;5;33m",
+		"magenta": "\033[38;5;125m",
+		"cyan":    "\033[38;5;37m",
+		"white":   "\033[38;5;245m",
+		"dim":     "\033[38;5;240m",
+	},
+	// No hues; warnings are bold instead of red
+	"mono": {
+		"red": "\033[1m",
+		"dim": "\033[2m",
+	},
+}
+
+// SetTheme switches the palette to the named theme.
+func SetTheme(name string) error {
+	t, ok := Themes[name]
+	if !ok {
+		names := make([]string, 0, len(Themes))
+		for n := range Themes {
+			names = append(names, n)
+		}
+		slices.Sort(names)
+		return fmt.Errorf("unknown theme %q (have %s)", name, strings.Join(names, ", "))
+	}
+	Red, Green, Yellow, Blue = t["red"], t["green"], t["yellow"], t["blue"]
+	Magenta, Cyan, White, Dim = t["magenta"], t["cyan"], t["white"], t["dim"]
+	return nil
+}
@@ -0,0 +1,18 @@
+package main
+
+import "powermon/power"
+
+// A sink is one of the outputs every sample is handed to: a log file, the
+// socket, InfluxDB, notifications, or whatever is on stdout. Any number
+// run side by side; only stdout has room for just one. The Prometheus
+// endpoint and the HTTP API read the current sample when asked instead.
+type sink interface {
+	Consume(s power.Sample) error
+}
+
+// sinkFunc makes a plain function a sink.
+type sinkFunc func(s power.Sample) error
+
+func (f sinkFunc) Consume(s power.Sample) error {
+	return f(s)
+}
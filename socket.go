@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"powermon/power"
+)
+
+// socketHub streams every sample as a line of JSON to each client connected
+// to a Unix socket, for frontends that want powermon as a data backend.
+type socketHub struct {
+	ln      net.Listener
+	mu      sync.Mutex
+	clients map[net.Conn]bool
+}
+
+// serveSocket listens on a Unix socket at path, replacing a stale socket
+// left by an earlier run, and accepts clients in the background.
+func serveSocket(path string) (*socketHub, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &socketHub{ln: ln, clients: map[net.Conn]bool{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				continue
+			}
+			h.mu.Lock()
+			h.clients[conn] = true
+			h.mu.Unlock()
+		}
+	}()
+	return h, nil
+}
+
+// Consume sends s to every client. A client that can't keep up is
+// dropped rather than allowed to stall the sample loop.
+func (h *socketHub) Consume(s power.Sample) error {
+	b, err := sampleJSON(s)
+	if err != nil {
+		return fmt.Errorf("writing to socket: %w", err)
+	}
+	b = append(b, '\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for conn := range h.clients {
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write(b); err != nil {
+			conn.Close()
+			delete(h.clients, conn)
+		}
+	}
+	return nil
+}
+
+// Close disconnects every client and removes the socket.
+func (h *socketHub) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for conn := range h.clients {
+		conn.Close()
+	}
+	return h.ln.Close()
+}
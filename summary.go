@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"powermon/power"
+)
+
+// printSummary reports the session as a whole: average and peak chip
+// power, energy used, and how the battery level moved.
+func printSummary(w io.Writer) {
+	s, stats := data.Sample(), stats.Snapshot()
+
+	if stats.Start.IsZero() {
+		fmt.Fprintln(w, "No samples recorded.")
+		return
+	}
+	elapsed := s.Time.Sub(stats.Start)
+	fmt.Fprintf(w, "Session: %s\n", elapsed.Round(time.Second))
+	// The average is over the samples, since the energy leaves out gaps in
+	// sampling that the elapsed time doesn't
+	if chip, ok := stats.TotalChip.Summary(); ok {
+		fmt.Fprintf(w, "  Chip power: avg %.2f W, peak %.2f W at %s\n",
+			chip.Avg, stats.PeakChip, stats.PeakAt.Format("15:04:05"))
+	}
+	fmt.Fprintf(w, "  Energy:     chip %.3f Wh, battery drain %.3f Wh\n", stats.PackageWh, stats.DrainWh)
+	if stats.StartBattery > 0 {
+		fmt.Fprintf(w, "  Battery:    %d%% → %d%% (%+d%%)\n", stats.StartBattery, s.BatteryPct, s.BatteryPct-stats.StartBattery)
+	}
+}
+
+// railSummary is one rail's session min/avg/max in the summary file.
+type railSummary struct {
+	Min float64 `json:"min"`
+	Avg float64 `json:"avg"`
+	Max float64 `json:"max"`
+}
+
+// sessionSummary is the machine-readable form of printSummary.
+type sessionSummary struct {
+	Start        time.Time              `json:"start,omitzero"`
+	End          time.Time              `json:"end"`
+	DurationS    float64                `json:"duration_s"`
+	Samples      int                    `json:"samples"`
+	Rails        map[string]railSummary `json:"rails_w,omitempty"`
+	PeakChipAt   time.Time              `json:"peak_chip_at,omitzero"`
+	PackageWh    float64                `json:"package_wh"`
+	DrainWh      float64                `json:"drain_wh"`
+	BatteryStart int                    `json:"battery_start_pct,omitempty"`
+	BatteryEnd   int                    `json:"battery_end_pct,omitempty"`
+}
+
+// summarize gathers the session so far into a sessionSummary.
+func summarize() sessionSummary {
+	s, stats := data.Sample(), stats.Snapshot()
+	sum := sessionSummary{
+		Start:     stats.Start,
+		End:       s.Time,
+		Samples:   stats.TotalChip.N,
+		PackageWh: stats.PackageWh,
+		DrainWh:   stats.DrainWh,
+	}
+	if !stats.Start.IsZero() {
+		sum.DurationS = s.Time.Sub(stats.Start).Seconds()
+		sum.PeakChipAt = stats.PeakAt
+		sum.BatteryStart, sum.BatteryEnd = stats.StartBattery, s.BatteryPct
+		sum.Rails = map[string]railSummary{}
+		for name, t := range map[string]power.Totals{
+			"cpu": stats.TotalCPU, "gpu": stats.TotalGPU, "ane": stats.TotalANE, "package": stats.TotalChip,
+		} {
+			r, _ := t.Summary()
+			sum.Rails[name] = railSummary(r)
+		}
+	}
+	return sum
+}
+
+// writeSummary saves the session summary to path as JSON.
+func writeSummary(path string) error {
+	b, err := json.MarshalIndent(summarize(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"text/template"
+	"time"
+
+	"powermon/power"
+)
+
+// templateData is what a --template is executed on: the current readings,
+// under PowerDataSnapshot's field names, the sample's time and the session
+// so far.
+type templateData struct {
+	power.PowerDataSnapshot
+	Time    time.Time
+	Session sessionSummary
+}
+
+// templateOut prints each sample through a --template.
+type templateOut struct {
+	tmpl *template.Template
+	w    io.Writer
+}
+
+// Consume executes the template for s, ending the output with a newline if
+// the template doesn't.
+func (t *templateOut) Consume(s power.Sample) error {
+	var b bytes.Buffer
+	err := t.tmpl.Execute(&b, templateData{
+		PowerDataSnapshot: data.Snapshot(),
+		Time:              s.Time,
+		Session:           summarize(),
+	})
+	if err != nil {
+		return fmt.Errorf("writing template output: %w", err)
+	}
+	if !bytes.HasSuffix(b.Bytes(), []byte("\n")) {
+		b.WriteByte('\n')
+	}
+	if _, err := t.w.Write(b.Bytes()); err != nil {
+		return fmt.Errorf("writing template output: %w", err)
+	}
+	return nil
+}
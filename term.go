@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// terminalWidth returns the number of columns of the terminal on stdout, or
+// 0 if stdout isn't a terminal.
+func terminalWidth() int {
+	var ws struct {
+		Row, Col, Xpixel, Ypixel uint16
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdout.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 {
+		return 0
+	}
+	return int(ws.Col)
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorSupported guesses from the environment whether stdout understands
+// ANSI colors: it has to be a terminal, and one that sets TERM to something
+// other than dumb. Consoles that would print the escape codes literally
+// leave TERM unset. The user can settle it with NO_COLOR (no-color.org),
+// which wins, or FORCE_COLOR.
+func colorSupported() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	if force := os.Getenv("FORCE_COLOR"); force != "" && force != "0" && force != "false" {
+		return true
+	}
+	term := os.Getenv("TERM")
+	return isTerminal(os.Stdout) && term != "" && term != "dumb"
+}
+
+// rawInput switches the terminal on stdin to reading single unechoed
+// keystrokes. Ctrl+C still raises SIGINT. The returned func restores the
+// previous mode.
+func rawInput() (restore func(), err error) {
+	fd := os.Stdin.Fd()
+	var old syscall.Termios
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlGetTermios, uintptr(unsafe.Pointer(&old))); errno != 0 {
+		return nil, errno
+	}
+	raw := old
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlSetTermios, uintptr(unsafe.Pointer(&raw))); errno != 0 {
+		return nil, errno
+	}
+	return func() {
+		syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlSetTermios, uintptr(unsafe.Pointer(&old)))
+	}, nil
+}
+
+// readKeys sends each key typed on stdin to keys until stdin closes. Arrow
+// keys arrive whole as their escape sequences, e.g. "\033[D" for left.
+func readKeys(keys chan<- string) {
+	buf := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		for in := string(buf[:n]); in != ""; {
+			k := in[:1]
+			if strings.HasPrefix(in, "\033[") && len(in) >= 3 {
+				k = in[:3]
+			}
+			keys <- k
+			in = in[len(k):]
+		}
+	}
+}